@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"hyperspike.io/pivot/internal/backup"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "back up the Gitea database and repositories to a local archive or S3",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		to := cmd.Flag("to").Value.String()
+		if to == "" {
+			to = backup.Name(time.Now())
+		}
+		to = backup.Resolve(to, time.Now())
+		w, err := backup.OpenTarget(ctx, to, s3Options(cmd))
+		if err != nil {
+			log.Fatalw("failed to open backup target", "error", err, "to", to)
+		}
+		if err := backup.Create(ctx, log, k8s, w, backup.Options{
+			Namespace: cmd.Flag("namespace").Value.String(),
+			Pod:       cmd.Flag("pod").Value.String(),
+			Container: cmd.Flag("container").Value.String(),
+		}); err != nil {
+			w.Abort(err)
+			log.Fatalw("failed to create backup", "error", err)
+		}
+		if err := w.Close(); err != nil {
+			log.Fatalw("failed to write backup", "error", err, "to", to)
+		}
+		log.Infow("Backup complete", "to", to)
+	},
+}
+
+// addS3Flags adds the flags of the S3 compatible store backups go to, the
+// keys default to the usual AWS environment variables.
+func addS3Flags(flags *pflag.FlagSet) {
+	flags.String("s3-endpoint", "", "host:port of the S3 compatible store [env PIVOT_S3_ENDPOINT]")
+	flags.String("s3-access-key", "", "S3 access key [env AWS_ACCESS_KEY_ID]")
+	flags.String("s3-secret-key", "", "S3 secret key [env AWS_SECRET_ACCESS_KEY]")
+	flags.String("s3-region", "", "S3 region")
+	flags.Bool("s3-insecure", false, "talk plain HTTP to the S3 endpoint")
+}
+
+func s3Options(cmd *cobra.Command) backup.S3Options {
+	opts := backup.S3Options{
+		Endpoint:  cmd.Flag("s3-endpoint").Value.String(),
+		AccessKey: cmd.Flag("s3-access-key").Value.String(),
+		SecretKey: cmd.Flag("s3-secret-key").Value.String(),
+		Region:    cmd.Flag("s3-region").Value.String(),
+		Insecure:  cmd.Flag("s3-insecure").Value.String() == "true",
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = os.Getenv("PIVOT_S3_ENDPOINT")
+	}
+	if opts.AccessKey == "" {
+		opts.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if opts.SecretKey == "" {
+		opts.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return opts
+}
+
+func init() {
+	backupCmd.Flags().String("to", "", "path or s3://bucket/key of the archive, a trailing slash adds a timestamped name (pivot-backup-<time>.tar.gz in the current directory if not set)")
+	backupCmd.Flags().String("pod", "gitea-0", "name of the Gitea pod")
+	backupCmd.Flags().StringP("namespace", "n", kubernetes.DEFAULT, "namespace of the Gitea pod")
+	backupCmd.Flags().String("container", "", "container of the Gitea pod (the first if not set)")
+	addS3Flags(backupCmd.Flags())
+	rootCmd.AddCommand(backupCmd)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "manage the workload clusters Argo CD deploys to",
+}
+
+var clusterAddCmd = &cobra.Command{
+	Use:   "add <kube-context>",
+	Short: "register the cluster of a kubeconfig context with Argo CD",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		dryRun := cmd.Flag("dry-run").Value.String() == "true"
+		name := cmd.Flag("name").Value.String()
+		if name == "" {
+			name = args[0]
+		}
+		labels, err := cmd.Flags().GetStringToString("label")
+		if err != nil {
+			log.Fatalw("failed to read labels", "error", err)
+		}
+
+		// the workload cluster is reached through the same kubeconfig
+		targetFlags := genericclioptions.NewConfigFlags(true)
+		targetFlags.KubeConfig = kubeFlags.KubeConfig
+		targetContext := args[0]
+		targetFlags.Context = &targetContext
+		target, err := kubernetes.NewK8s(ctx, log, targetFlags, dryRun)
+		if err != nil {
+			log.Fatalw("failed to create k8s for the workload cluster", "error", err)
+		}
+		creds, err := target.CreateClusterAccess()
+		if err != nil {
+			log.Fatalw("failed to create cluster access", "error", err)
+		}
+		if server := cmd.Flag("server").Value.String(); server != "" {
+			creds.Server = server
+		}
+
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, dryRun)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		if err := k8s.CreateClusterSecret(name, creds, labels); err != nil {
+			log.Fatalw("failed to create cluster secret", "error", err)
+		}
+
+		if dryRun {
+			log.Infow("Dry run: Adding project destination", "cluster", name, "server", creds.Server)
+			return
+		}
+		// the AppProject is managed by Argo CD, so it is changed in the repo
+		r, err := git.OpenRepo(ctx, log, cmd.Flag("repo").Value.String())
+		if err != nil {
+			log.Fatalw("failed to open repo", "error", err)
+		}
+		changed, err := kubernetes.AddProjectDestination(filepath.Join(r.Path, kubernetes.INIT, "init.yaml"), name, creds.Server)
+		if err != nil {
+			log.Fatalw("failed to add project destination", "error", err)
+		}
+		if !changed {
+			log.Infow("Cluster already a destination of the platform project", "cluster", name)
+			return
+		}
+		if err := r.AddExisting(kubernetes.INIT + "/init.yaml"); err != nil {
+			log.Fatalw("failed to commit project destination", "error", err)
+		}
+		user := cmd.Flag("user").Value.String()
+		pass, err := k8s.GetUserPassword(kubernetes.DEFAULT, user)
+		if err != nil {
+			log.Fatalw("failed to get password", "error", err)
+		}
+		inCluster := cmd.Flag("in-cluster").Value.String() == "true" || kubernetes.InCluster(kubeFlags)
+		if _, err := connectGitea(ctx, log, inCluster); err != nil {
+			log.Fatalw("failed to connect to gitea", "error", err)
+		}
+		if err := r.PushBasic("local", user, pass); err != nil {
+			log.Fatalw("failed to push", "error", err)
+		}
+		log.Infow("Registered cluster", "cluster", name, "server", creds.Server)
+	},
+}
+
+func init() {
+	clusterAddCmd.Flags().String("name", "", "name of the cluster in Argo CD (the context if not set)")
+	clusterAddCmd.Flags().StringToString("label", map[string]string{}, "labels cluster generators select the cluster by, e.g. --label env=prod")
+	clusterAddCmd.Flags().String("server", "", "API server URL Argo CD reaches the cluster at (the kubeconfig's if not set)")
+	clusterAddCmd.Flags().String("repo", "infra", "path of the infra repository")
+	clusterAddCmd.Flags().StringP("user", "u", "pivot", "gitea user to push as [env PIVOT_USER]")
+	clusterAddCmd.Flags().BoolP("dry-run", "d", false, "dry run")
+	clusterAddCmd.Flags().Bool("in-cluster", false, "connect to the Gitea Service instead of a port-forward (detected automatically) [env PIVOT_IN_CLUSTER]")
+	clusterCmd.AddCommand(clusterAddCmd)
+	rootCmd.AddCommand(clusterCmd)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"hyperspike.io/pivot/internal/gitea"
+	"hyperspike.io/pivot/internal/kubernetes"
+	"hyperspike.io/pivot/internal/proxy"
+)
+
+// giteaAddress returns the URL of Gitea, in-cluster the Gitea Service is
+// reachable directly, otherwise it is port-forwarded to localhost.
+func giteaAddress(inCluster bool) string {
+	if inCluster {
+		return kubernetes.GiteaURL
+	}
+	return "https://localhost:3000"
+}
+
+// connectGitea returns the URL Gitea is reachable at once it answers its
+// health check, starting a port-forward for the lifetime of the process when
+// not in-cluster.
+func connectGitea(ctx context.Context, log *zap.SugaredLogger, inCluster bool) (string, error) {
+	giteaURL := giteaAddress(inCluster)
+	if !inCluster {
+		go func() {
+			forwarder, err := proxy.NewForwarder(ctx, log, kubeFlags)
+			if err != nil {
+				log.Fatalw("failed to create forwarder", "error", err)
+			}
+			if err := forwarder.ForwardPorts("", "", ""); err != nil {
+				log.Fatalw("failed to forward ports", "error", err)
+			}
+		}()
+	}
+	client := gitea.NewClient(ctx, log, giteaURL, "", "")
+	for tries := 0; tries < 60; tries++ {
+		if client.Healthy() {
+			return giteaURL, nil
+		}
+		time.Sleep(3 * time.Second)
+	}
+	return "", fmt.Errorf("gitea at %s is not healthy", giteaURL)
+}
+
+// rotateDeployToken issues a new access token for the read-only deploy user,
+// points the Argo CD repository secret at it and then revokes the tokens it
+// replaced.
+func rotateDeployToken(ctx context.Context, log *zap.SugaredLogger, k8s *kubernetes.K8s, giteaURL string) error {
+	deployPass, err := k8s.GetSecretValue(kubernetes.DEFAULT, kubernetes.DeployUser+"-password", "password")
+	if err != nil {
+		return err
+	}
+	client := gitea.NewClient(ctx, log, giteaURL, kubernetes.DeployUser, deployPass)
+	// the operator creates the deploy user asynchronously
+	for tries := 0; tries < 60; tries++ {
+		if err = client.CheckAuth(); err == nil {
+			break
+		}
+		log.Warnw("deploy user is not ready", "error", err, "try", tries)
+		time.Sleep(3 * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	token, old, err := client.RotateToken(kubernetes.DeployUser, kubernetes.DeployTokenScopes, func(token *gitea.Token) error {
+		return k8s.UpdateRepoCredentials(kubernetes.DeployUser, token.Sha1)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infow("Rotated deploy token", "token", token.Name, "revoked", len(old))
+	return nil
+}
+
+// registerWebhook points a push webhook of the infra repo at hookURL, Argo
+// CD's webhook endpoint, so pushes are synced immediately instead of on the
+// next poll. The shared secret is kept across runs.
+func registerWebhook(ctx context.Context, log *zap.SugaredLogger, k8s *kubernetes.K8s, giteaURL, user, pass, hookURL string) error {
+	if hookURL == "" {
+		log.Info("No --webhook-url, Argo CD polls the repo")
+		return nil
+	}
+	secret, err := k8s.WebhookSecret()
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		if secret, err = randString(32); err != nil {
+			return err
+		}
+		if err := k8s.SetWebhookSecret(secret); err != nil {
+			return err
+		}
+	}
+	client := gitea.NewClient(ctx, log, giteaURL, user, pass)
+	hook, err := client.EnsureHook("infra", "infra", gitea.Hook{
+		Type: "gogs",
+		Config: map[string]string{
+			"url":          hookURL,
+			"content_type": "json",
+			"secret":       secret,
+		},
+		Events: []string{"push"},
+		Active: true,
+	})
+	if err != nil {
+		return err
+	}
+	log.Infow("Registered Argo CD webhook", "hook", hook.ID, "url", hookURL)
+	return nil
+}
@@ -2,29 +2,103 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
 	"hyperspike.io/pivot/internal/kubernetes"
 )
 
+// credential is a user and password of a platform service
+type credential struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
 var passwordCmd = &cobra.Command{
 	Use:   "password",
-	Short: "fetch the generated pivot password",
+	Short: "fetch the Gitea user and Argo CD admin passwords",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.TODO()
-		kube, err := kubernetes.NewK8s(ctx, getLogger(cmd), cmd.Flag("context").Value.String(), false)
+		log := getLogger(cmd)
+		kube, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
 		if err != nil {
-			panic(err)
+			log.Fatalw("failed to create k8s", "error", err)
 		}
-		pass, err := kube.GetPivotPassword()
+		user := cmd.Flag("user").Value.String()
+		pass, err := kube.GetUserPassword(cmd.Flag("namespace").Value.String(), user)
 		if err != nil {
-			panic(err)
+			log.Fatalw("failed to get gitea password", "error", err, "user", user)
+		}
+		creds := map[string]credential{kubernetes.GITEA: {User: user, Password: pass}}
+		if cmd.Flag("argocd").Value.String() == "true" {
+			argo, err := kube.GetArgoAdminPassword()
+			if err != nil {
+				log.Fatalw("failed to get argocd admin password", "error", err)
+			}
+			creds[kubernetes.ARGOCD] = credential{User: "admin", Password: argo}
+		}
+
+		if err := writeCredentials(os.Stdout, cmd.Flag("output").Value.String(), creds); err != nil {
+			log.Fatalw("failed to write credentials", "error", err)
 		}
-		fmt.Println(pass)
 	},
 }
 
+// writeCredentials writes the Gitea and, if fetched, Argo CD credentials to
+// w as plain passwords, JSON or shell exports.
+func writeCredentials(w io.Writer, output string, creds map[string]credential) error {
+	switch output {
+	case "plain":
+		for _, name := range []string{kubernetes.GITEA, kubernetes.ARGOCD} {
+			if c, ok := creds[name]; ok {
+				if _, err := fmt.Fprintln(w, c.Password); err != nil {
+					return err
+				}
+			}
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(creds)
+	case "env":
+		for _, name := range []string{kubernetes.GITEA, kubernetes.ARGOCD} {
+			c, ok := creds[name]
+			if !ok {
+				continue
+			}
+			prefix := strings.ToUpper(name)
+			if _, err := fmt.Fprintf(w, "export %s_USER=%s\nexport %s_PASSWORD=%s\n", prefix, shellQuote(c.User), prefix, shellQuote(c.Password)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, expected plain, json or env", output)
+	}
+	return nil
+}
+
+// shellQuote single quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func init() {
+	viper.AutomaticEnv()
+	passwordCmd.Flags().StringP("user", "u", "pivot", "gitea user [env PIVOT_USER]")
+	if err := viper.BindPFlag("PIVOT_USER", passwordCmd.Flags().Lookup("user")); err != nil {
+		panic(err)
+	}
+	passwordCmd.Flags().StringP("namespace", "n", kubernetes.DEFAULT, "namespace of the gitea user secret [env PIVOT_GITEA_NAMESPACE]")
+	if err := viper.BindPFlag("PIVOT_GITEA_NAMESPACE", passwordCmd.Flags().Lookup("namespace")); err != nil {
+		panic(err)
+	}
+	passwordCmd.Flags().Bool("argocd", false, "also fetch the Argo CD initial admin password")
+	passwordCmd.Flags().StringP("output", "o", "plain", "output format, plain, json or env")
 	rootCmd.AddCommand(passwordCmd)
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"testing"
+
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, pass := range []string{"plain", "it's", "'", `a'b"c$d` + "`e`", ""} {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(pass)).Output()
+		if err != nil {
+			t.Fatalf("Expected %s to be a valid shell word %v", shellQuote(pass), err)
+		}
+		if string(out) != pass {
+			t.Errorf("Expected the shell to read back %q, got %q", pass, out)
+		}
+	}
+}
+
+func TestWriteCredentials(t *testing.T) {
+	gitea := map[string]credential{kubernetes.GITEA: {User: "pivot", Password: "it's"}}
+	both := map[string]credential{
+		kubernetes.GITEA:  {User: "pivot", Password: "it's"},
+		kubernetes.ARGOCD: {User: "admin", Password: "argo"},
+	}
+	for _, tc := range []struct {
+		output string
+		creds  map[string]credential
+		want   string
+	}{
+		{"plain", gitea, "it's\n"},
+		{"plain", both, "it's\nargo\n"},
+		{"env", gitea, "export GITEA_USER='pivot'\nexport GITEA_PASSWORD='it'\\''s'\n"},
+		{"env", both, "export GITEA_USER='pivot'\nexport GITEA_PASSWORD='it'\\''s'\nexport ARGOCD_USER='admin'\nexport ARGOCD_PASSWORD='argo'\n"},
+	} {
+		buf := &bytes.Buffer{}
+		if err := writeCredentials(buf, tc.output, tc.creds); err != nil {
+			t.Fatalf("writeCredentials %s failed %v", tc.output, err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("Expected %s output %q, got %q", tc.output, tc.want, buf.String())
+		}
+	}
+
+	for _, creds := range []map[string]credential{gitea, both} {
+		buf := &bytes.Buffer{}
+		if err := writeCredentials(buf, "json", creds); err != nil {
+			t.Fatalf("writeCredentials json failed %v", err)
+		}
+		decoded := map[string]map[string]string{}
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("Expected valid JSON %v", err)
+		}
+		if len(decoded) != len(creds) {
+			t.Errorf("Expected an entry per fetched credential, got %v", decoded)
+		}
+		for name, c := range creds {
+			if decoded[name]["user"] != c.User || decoded[name]["password"] != c.Password {
+				t.Errorf("Expected %s to be %v, got %v", name, c, decoded[name])
+			}
+		}
+	}
+
+	if err := writeCredentials(&bytes.Buffer{}, "yaml", gitea); err == nil {
+		t.Errorf("Expected an unknown output format to fail")
+	}
+}
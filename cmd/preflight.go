@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "check the cluster can run the platform without changing it",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		opts, err := preflightOptions(cmd)
+		if err != nil {
+			log.Fatalw("invalid profile", "error", err)
+		}
+		if opts.Namespaced {
+			namespaces, err := cmd.Flags().GetStringSlice("namespace")
+			if err != nil {
+				log.Fatalw("failed to read namespaces", "error", err)
+			}
+			k8s.SetNamespaced(namespaces)
+		}
+		checks, err := preflight(ctx, log, k8s, opts)
+		if err != nil {
+			log.Fatalw("failed to run preflight checks", "error", err)
+		}
+		switch cmd.Flag("output").Value.String() {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(checks); err != nil {
+				log.Fatalw("failed to encode checks", "error", err)
+			}
+		case "table":
+			printChecks(checks)
+		default:
+			log.Fatalw("unknown output format", "output", cmd.Flag("output").Value.String())
+		}
+		if kubernetes.Failed(checks) {
+			os.Exit(1)
+		}
+	},
+}
+
+// preflightOptions checks against the capacity of the selected profile.
+func preflightOptions(cmd *cobra.Command) (kubernetes.PreflightOptions, error) {
+	profile, err := kubernetes.GetProfile(cmd.Flag("profile").Value.String())
+	if err != nil {
+		return kubernetes.PreflightOptions{}, err
+	}
+	return kubernetes.PreflightOptions{
+		Namespaced: cmd.Flag("namespaced").Value.String() == "true",
+		Valkey:     cmd.Flag("valkey").Value.String() == "true" || profile.Valkey,
+		Profile:    profile,
+	}, nil
+}
+
+// preflight runs the cluster checks and checks every upstream source the
+// repo is generated from can be reached.
+func preflight(ctx context.Context, log *zap.SugaredLogger, k8s *kubernetes.K8s, opts kubernetes.PreflightOptions) ([]kubernetes.Check, error) {
+	checks, err := k8s.Preflight(opts)
+	if err != nil {
+		return nil, err
+	}
+	repoOpts, err := repoOptions(opts.Namespaced, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	unreachable := []string{}
+	limited := []string{}
+	for _, src := range git.Sources(repoOpts) {
+		err := git.CheckSource(ctx, src)
+		switch {
+		case errors.Is(err, git.ErrRateLimited):
+			log.Debugw("upstream source rate limited", "url", src, "error", err)
+			limited = append(limited, src)
+		case err != nil:
+			log.Debugw("upstream source unreachable", "url", src, "error", err)
+			unreachable = append(unreachable, src)
+		}
+	}
+	sources := kubernetes.Check{Name: "upstream sources", Status: kubernetes.CheckPass}
+	switch {
+	case len(unreachable) > 0:
+		sources.Status = kubernetes.CheckFail
+		sources.Message = "cannot reach " + strings.Join(unreachable, ", ")
+	case len(limited) > 0:
+		// the GitHub API rate limits unauthenticated requests, the run
+		// may still get through
+		sources.Status = kubernetes.CheckWarn
+		sources.Message = "rate limited, cannot check " + strings.Join(limited, ", ")
+	}
+	return append(checks, sources), nil
+}
+
+func printChecks(checks []kubernetes.Check) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+	for _, c := range checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Status, orDash(c.Message))
+	}
+	_ = w.Flush()
+}
+
+func init() {
+	preflightCmd.Flags().Bool("namespaced", false, "check for a namespace-scoped install")
+	preflightCmd.Flags().StringSliceP("namespace", "n", []string{}, "namespaces Argo CD manages with --namespaced besides argocd and those of the components")
+	preflightCmd.Flags().BoolP("valkey", "k", false, "check for valkey support")
+	preflightCmd.Flags().String("profile", kubernetes.DefaultProfile, "the profile to check the capacity for, minimal, standard or ha")
+	preflightCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	rootCmd.AddCommand(preflightCmd)
+}
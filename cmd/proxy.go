@@ -14,7 +14,7 @@ var proxyCmd = &cobra.Command{
 	Short: "proxy a service",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.TODO()
-		forwarder, err := proxy.NewForwarder(ctx, getLogger(cmd), cmd.Flag("context").Value.String())
+		forwarder, err := proxy.NewForwarder(ctx, getLogger(cmd), kubeFlags)
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"hyperspike.io/pivot/internal/backup"
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "rebuild a cluster from an infra repository or a backup archive",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		from := cmd.Flag("from").Value.String()
+		repo := cmd.Flag("repo").Value.String()
+		r, err := restoreRepo(ctx, log, cmd, from, repo)
+		if err != nil {
+			log.Fatalw("failed to restore repo", "error", err, "from", from)
+		}
+		repo = r.Path
+		if _, err := os.Stat(filepath.Join(repo, "argocd", "progressive-syncs.yaml")); err != nil {
+			log.Warnw("The repo was generated without --rolling-sync, the components sync in parallel instead of wave by wave", "repo", repo)
+		}
+		dryRun := cmd.Flag("dry-run").Value.String() == "true"
+		inCluster := cmd.Flag("in-cluster").Value.String() == "true" || kubernetes.InCluster(kubeFlags)
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, dryRun)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		head, err := r.Head()
+		if err != nil {
+			log.Fatalw("failed to read repo HEAD", "error", err)
+		}
+		k8s.SetSource(head, r.Versions)
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		k8s.WatchProblems(watchCtx)
+
+		// the same order pivot run applies them in
+		if err := k8s.ApplyKustomize(filepath.Join(repo, "cert-manager")); err != nil {
+			log.Fatalw("failed to apply cert-manager", "error", err)
+		}
+		if err := k8s.ApplyKustomize(filepath.Join(repo, "argocd")); err != nil {
+			log.Fatalw("failed to apply argocd", "error", err)
+		}
+		if err := k8s.CreateNamespace("postgres-operator"); err != nil {
+			log.Fatalw("failed to create postgres-operator namespace", "error", err)
+		}
+		for _, c := range []string{"postgres-operator", "valkey-operator", "gitea-operator"} {
+			if err := k8s.ApplyKustomize(filepath.Join(repo, c)); err != nil {
+				log.Fatalw("failed to apply "+c, "error", err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(repo, kubernetes.ISSUERS)); err == nil {
+			// cert-manager's webhook has to come up before it accepts issuers
+			for tries := 0; ; tries++ {
+				if err = k8s.ApplyKustomize(filepath.Join(repo, kubernetes.ISSUERS)); err == nil || tries >= 60 {
+					break
+				}
+				log.Warnw("failed to apply issuers", "error", err, "try", tries)
+				time.Sleep(3 * time.Second)
+			}
+			if err != nil {
+				log.Fatalw("failed to apply issuers", "error", err)
+			}
+		}
+
+		// passwords are never committed, so the restored users get new ones
+		user := cmd.Flag("user").Value.String()
+		pass := cmd.Flag("password").Value.String()
+		if pass == "" {
+			if pass, err = randString(16); err != nil {
+				log.Fatalw("failed to generate password", "error", err)
+			}
+		}
+		deployPass, err := randString(32)
+		if err != nil {
+			log.Fatalw("failed to generate deploy password", "error", err)
+		}
+		if err := k8s.CreatePasswordSecret(user, pass); err != nil {
+			log.Fatalw("failed to create password secret", "error", err)
+		}
+		if err := k8s.CreatePasswordSecret(kubernetes.DeployUser, deployPass); err != nil {
+			log.Fatalw("failed to create deploy password secret", "error", err)
+		}
+		if err := k8s.ApplyKustomize(filepath.Join(repo, kubernetes.GITEA)); err != nil {
+			log.Fatalw("failed to apply gitea", "error", err)
+		}
+
+		giteaURL := giteaAddress(inCluster)
+		if err := r.SetRemote("local", giteaURL+"/infra/infra.git"); err != nil {
+			log.Fatalw("failed to set remote", "error", err)
+		}
+		if !dryRun {
+			if _, err := connectGitea(ctx, log, inCluster); err != nil {
+				log.Fatalw("failed to connect to gitea", "error", err)
+			}
+			// the operator creates the user and repo asynchronously
+			for tries := 0; ; tries++ {
+				if err = r.PushAllBasic("local", user, pass); err == nil || tries >= 60 {
+					break
+				}
+				log.Warnw("push failed", "error", err, "try", tries)
+				time.Sleep(3 * time.Second)
+			}
+			if err != nil {
+				log.Fatalw("failed to push to remote", "error", err)
+			}
+		}
+
+		if !dryRun {
+			// Gitea serves a certificate by now, so a pivot CA has been issued
+			ca, err := k8s.IssuedCA()
+			if err != nil {
+				log.Fatalw("failed to read the issuer CA", "error", err)
+			}
+			k8s.SetRepoCA(ca)
+		}
+		if err := k8s.CreateRepoSecret(kubernetes.DeployUser, ""); err != nil {
+			log.Fatalw("failed to create repo secret", "error", err)
+		}
+		if err := k8s.ApplyKustomize(filepath.Join(repo, kubernetes.INIT)); err != nil {
+			log.Fatalw("failed to apply init", "error", err)
+		}
+		if !dryRun {
+			if err := rotateDeployToken(ctx, log, k8s, giteaURL); err != nil {
+				log.Fatalw("failed to create deploy token", "error", err)
+			}
+			if err := registerWebhook(ctx, log, k8s, giteaURL, user, pass, cmd.Flag("webhook-url").Value.String()); err != nil {
+				log.Warnw("failed to register webhook", "error", err)
+			}
+		}
+
+		timeout, err := cmd.Flags().GetDuration("wait-timeout")
+		if err != nil {
+			log.Fatalw("failed to read wait timeout", "error", err)
+		}
+		if timeout > 0 {
+			statuses, err := k8s.WaitForApplications(timeout)
+			if err != nil {
+				for _, s := range statuses {
+					if !s.Ready() {
+						log.Errorw("application not ready", "application", s.Name, "sync", s.Sync, "health", s.Health, "messages", s.Messages)
+					}
+				}
+				log.Fatalw("handoff to Argo CD failed", "error", err)
+			}
+		}
+	},
+}
+
+// restoreRepo opens from when it is an existing repository, otherwise it
+// clones the infra repository out of the backup archive at from into repo.
+func restoreRepo(ctx context.Context, log *zap.SugaredLogger, cmd *cobra.Command, from, repo string) (*git.Spool, error) {
+	if git.RepoExists(from) {
+		return git.OpenRepo(ctx, log, from)
+	}
+	if _, err := os.Stat(repo); err == nil {
+		return nil, fmt.Errorf("%s already exists, remove it or pass another --repo", repo)
+	}
+	src, err := backup.OpenSource(ctx, from, s3Options(cmd))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			log.Errorw("failed to close backup archive", "error", err)
+		}
+	}()
+	tmp, err := os.MkdirTemp("", "pivot-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmp); err != nil {
+			log.Errorw("failed to remove temporary directory", "error", err)
+		}
+	}()
+	if err := backup.ExtractRepository(src, "infra", "infra", tmp); err != nil {
+		return nil, err
+	}
+	return git.CloneRepo(ctx, log, tmp, repo)
+}
+
+func init() {
+	restoreCmd.Flags().String("from", "infra", "infra repository directory, or path or s3://bucket/key of a pivot backup archive")
+	restoreCmd.Flags().String("repo", "infra", "path to clone the infra repository of a backup archive to")
+	restoreCmd.Flags().StringP("user", "u", "pivot", "gitea user [env PIVOT_USER]")
+	if err := viper.BindPFlag("PIVOT_USER", restoreCmd.Flags().Lookup("user")); err != nil {
+		panic(err)
+	}
+	restoreCmd.Flags().StringP("password", "p", "", "gitea password (generated if not set) [env PIVOT_PASSWD]")
+	restoreCmd.Flags().BoolP("dry-run", "d", false, "dry run")
+	restoreCmd.Flags().Bool("in-cluster", false, "run from a pod, pushing to the Gitea Service instead of a port-forward (detected automatically) [env PIVOT_IN_CLUSTER]")
+	restoreCmd.Flags().String("webhook-url", "", "Argo CD webhook endpoint Gitea notifies of pushes (Argo CD polls if not set)")
+	restoreCmd.Flags().Duration("wait-timeout", 15*time.Minute, "how long to wait for Argo CD to report the platform synced and healthy, 0 to not wait")
+	addS3Flags(restoreCmd.Flags())
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 var rootCmd = &cobra.Command{
@@ -10,9 +11,20 @@ var rootCmd = &cobra.Command{
 	Short: "Pivot is a tool for pivoting from bootstrap to GitOps",
 }
 
+// kubeFlags holds the kubectl-style connection flags shared by every command
+var kubeFlags = genericclioptions.NewConfigFlags(true)
+
 func main() {
 	rootCmd.PersistentFlags().StringP("format", "f", "text", "output format")
-	rootCmd.PersistentFlags().StringP("context", "c", "", "use an explicit Kubernetes context [env PIVOT_CONTEXT]")
+	// --namespace and --user mean the pivot namespace and Gitea user to pivot,
+	// --context keeps its -c shorthand
+	kubeFlags.Namespace = nil
+	kubeFlags.AuthInfoName = nil
+	kubeFlags.Context = nil
+	kubeFlags.AddFlags(rootCmd.PersistentFlags())
+	kubeContext := ""
+	rootCmd.PersistentFlags().StringVarP(&kubeContext, "context", "c", "", "use an explicit Kubernetes context [env PIVOT_CONTEXT]")
+	kubeFlags.Context = &kubeContext
 	if err := viper.BindPFlag("PIVOT_CONTEXT", rootCmd.PersistentFlags().Lookup("context")); err != nil {
 		panic(err)
 	}
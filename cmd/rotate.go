@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/gitea"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+var rotateTokenCmd = &cobra.Command{
+	Use:   "rotate-token",
+	Short: "rotate the read-only token Argo CD pulls the infra repo with",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		inCluster := cmd.Flag("in-cluster").Value.String() == "true" || kubernetes.InCluster(kubeFlags)
+		giteaURL, err := connectGitea(ctx, log, inCluster)
+		if err != nil {
+			log.Fatalw("failed to connect to gitea", "error", err)
+		}
+		if err := rotateDeployToken(ctx, log, k8s, giteaURL); err != nil {
+			log.Fatalw("failed to rotate deploy token", "error", err)
+		}
+	},
+}
+
+var rotatePasswordCmd = &cobra.Command{
+	Use:   "rotate-password",
+	Short: "rotate the password of the Gitea user and the credentials using it",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		user := cmd.Flag("user").Value.String()
+		pass := cmd.Flag("password").Value.String()
+		var err error
+		if pass == "" {
+			if pass, err = randString(16); err != nil {
+				log.Fatalw("failed to generate password", "error", err)
+			}
+		}
+		r, err := git.OpenRepo(ctx, log, cmd.Flag("repo").Value.String())
+		if err != nil {
+			log.Fatalw("failed to open repo", "error", err)
+		}
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		inCluster := cmd.Flag("in-cluster").Value.String() == "true" || kubernetes.InCluster(kubeFlags)
+		giteaURL, err := connectGitea(ctx, log, inCluster)
+		if err != nil {
+			log.Fatalw("failed to connect to gitea", "error", err)
+		}
+
+		if err := k8s.SetSecretData(kubernetes.DEFAULT, user+"-password", map[string]string{"password": pass}); err != nil {
+			log.Fatalw("failed to update password secret", "error", err)
+		}
+		// the gitea-operator reconciles the new password into Gitea
+		client := gitea.NewClient(ctx, log, giteaURL, user, pass)
+		for tries := 0; ; tries++ {
+			if err = client.CheckAuth(); err == nil || tries >= 60 {
+				break
+			}
+			log.Infow("Waiting for gitea to accept the new password", "try", tries)
+			time.Sleep(3 * time.Second)
+		}
+		if err != nil {
+			log.Fatalw("gitea did not accept the new password", "error", err)
+		}
+
+		// Argo CD normally pulls with the deploy token, older installs used
+		// the user's password
+		repoUser, err := k8s.GetSecretValue(kubernetes.ARGOCD, "infra-repo", "username")
+		if err != nil {
+			log.Fatalw("failed to read repository credentials", "error", err)
+		}
+		if repoUser == user {
+			if err := k8s.UpdateRepoCredentials(user, pass); err != nil {
+				log.Fatalw("failed to update repository credentials", "error", err)
+			}
+		}
+		// pivot keeps no credentials in the repo's remotes, pushes pass them
+		// explicitly
+		if err := r.FetchBasic("local", user, pass); err != nil {
+			log.Fatalw("failed to fetch with the new password", "error", err)
+		}
+		log.Infow("Rotated password, retrieve it with pivot password", "user", user)
+	},
+}
+
+func init() {
+	viper.AutomaticEnv()
+	rotateTokenCmd.Flags().Bool("in-cluster", false, "connect to the Gitea Service instead of a port-forward (detected automatically) [env PIVOT_IN_CLUSTER]")
+	if err := viper.BindPFlag("PIVOT_IN_CLUSTER", rotateTokenCmd.Flags().Lookup("in-cluster")); err != nil {
+		panic(err)
+	}
+	rootCmd.AddCommand(rotateTokenCmd)
+
+	rotatePasswordCmd.Flags().StringP("user", "u", "pivot", "gitea user [env PIVOT_USER]")
+	rotatePasswordCmd.Flags().StringP("password", "p", "", "new password (generated if not set)")
+	rotatePasswordCmd.Flags().String("repo", "infra", "path of the infra repository")
+	rotatePasswordCmd.Flags().Bool("in-cluster", false, "connect to the Gitea Service instead of a port-forward (detected automatically) [env PIVOT_IN_CLUSTER]")
+	rootCmd.AddCommand(rotatePasswordCmd)
+}
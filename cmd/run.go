@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"math/big"
-	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -14,8 +16,8 @@ import (
 	"go.uber.org/zap"
 
 	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/gitea"
 	"hyperspike.io/pivot/internal/kubernetes"
-	"hyperspike.io/pivot/internal/proxy"
 )
 
 var runCmd = &cobra.Command{
@@ -24,32 +26,178 @@ var runCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.TODO()
 		log := getLogger(cmd)
-		r, err := git.CreateRepo(ctx, log, "infra")
+		repo := cmd.Flag("repo").Value.String()
+		dryRun := cmd.Flag("dry-run").Value.String() == "true"
+		inCluster := cmd.Flag("in-cluster").Value.String() == "true" || kubernetes.InCluster(kubeFlags)
+		namespaced := cmd.Flag("namespaced").Value.String() == "true"
+		profile, err := kubernetes.GetProfile(cmd.Flag("profile").Value.String())
 		if err != nil {
-			return
+			log.Fatalw("invalid profile", "error", err)
 		}
-		dryRun := cmd.Flag("dry-run").Value.String() == "true"
-		k8s, err := kubernetes.NewK8s(ctx, log, cmd.Flag("context").Value.String(), dryRun)
+		valkey := cmd.Flag("valkey").Value.String() == "true" || profile.Valkey
+		// Argo CD core has no UI or API server to expose or send webhooks to
+		argoCore := profile.Argo == git.ArgoCore && !namespaced
+		if argoCore && cmd.Flag("argocd-host").Value.String() != "" {
+			log.Fatalf("--argocd-host cannot be used with the %s profile, Argo CD core has no server to expose", profile.Name)
+		}
+		if cmd.Flag("backup-schedule").Value.String() != "" && !strings.HasPrefix(cmd.Flag("backup-to").Value.String(), "s3://") {
+			log.Fatal("--backup-schedule requires --backup-to s3://bucket/prefix/")
+		}
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, dryRun)
 		if err != nil {
 			log.Fatalw("failed to create k8s", "error", err)
 		}
-		if err := k8s.ApplyKustomize("infra/cert-manager"); err != nil {
-			log.Fatalw("failed to apply cert-manager", "error", err)
+		namespaces, err := cmd.Flags().GetStringSlice("namespace")
+		if err != nil {
+			log.Fatalw("failed to read namespaces", "error", err)
 		}
-		if err := k8s.ApplyKustomize("infra/argocd"); err != nil {
-			log.Fatalw("failed to apply argocd", "error", err)
+		gatewayClass := cmd.Flag("gateway-class").Value.String()
+		if namespaced {
+			// the gateway component deploys to its own namespace
+			if gatewayNamespace := cmd.Flag("gateway-namespace").Value.String(); gatewayClass != "" && gatewayNamespace != "" {
+				namespaces = append(namespaces, gatewayNamespace)
+			}
+			k8s.SetNamespaced(namespaces)
+		} else if len(namespaces) > 0 {
+			log.Fatal("--namespace requires --namespaced")
+		}
+		destinations, err := cmd.Flags().GetStringSlice("destination")
+		if err != nil {
+			log.Fatalw("failed to read destinations", "error", err)
+		}
+		if err := k8s.SetDestinations(destinations); err != nil {
+			log.Fatalw("invalid destination", "error", err)
+		}
+		rollingSync := cmd.Flag("rolling-sync").Value.String() == "true"
+		if rollingSync {
+			k8s.SetRollingSync()
+		}
+		// check before generating the repo, so nothing is half applied
+		if cmd.Flag("skip-preflight").Value.String() != "true" {
+			opts, err := preflightOptions(cmd)
+			if err != nil {
+				log.Fatalw("invalid profile", "error", err)
+			}
+			checks, err := preflight(ctx, log, k8s, opts)
+			if err != nil {
+				log.Fatalw("failed to run preflight checks", "error", err)
+			}
+			for _, c := range checks {
+				switch c.Status {
+				case kubernetes.CheckFail:
+					log.Errorw("preflight check failed", "check", c.Name, "message", c.Message)
+				case kubernetes.CheckWarn:
+					log.Warnw("preflight check inconclusive", "check", c.Name, "message", c.Message)
+				}
+			}
+			if kubernetes.Failed(checks) {
+				log.Fatal("preflight checks failed, nothing was changed; run pivot preflight for details or --skip-preflight to ignore them")
+			}
+		}
+		repoOpts, err := repoOptions(namespaced, profile)
+		if err != nil {
+			log.Fatalw("failed to build repo options", "error", err)
+		}
+		repoOpts.RollingSync = rollingSync
+		repoOpts.GatewayAPI = cmd.Flag("expose").Value.String() == string(kubernetes.GatewayExpose) && gatewayClass != ""
+		r, err := git.CreateRepo(ctx, log, repo, repoOpts)
+		if err != nil {
+			return
+		}
+		head, err := r.Head()
+		if err != nil {
+			log.Fatalw("failed to read repo HEAD", "error", err)
+		}
+		k8s.SetSource(head, r.Versions)
+		exclude, err := cmd.Flags().GetStringSlice("exclude")
+		if err != nil {
+			log.Fatalw("failed to read exclude patterns", "error", err)
+		}
+		if err := k8s.SetGenerator(kubernetes.Generator(cmd.Flag("generator").Value.String()), exclude); err != nil {
+			log.Fatalw("invalid generator", "error", err)
+		}
+		// surface failing pods and warning events while bootstrapping
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		k8s.WatchProblems(watchCtx)
+		// namespaced, cert-manager, the operators and the issuer are
+		// installed by a cluster admin
+		if !namespaced {
+			if err := k8s.ApplyKustomize(filepath.Join(repo, "cert-manager")); err != nil {
+				log.Fatalw("failed to apply cert-manager", "error", err)
+			}
 		}
-		if err := k8s.CreateNamespace("postgres-operator"); err != nil {
-			log.Fatalw("failed to create postgres-operator namespace", "error", err)
+		if err := k8s.ApplyKustomize(filepath.Join(repo, "argocd")); err != nil {
+			log.Fatalw("failed to apply argocd", "error", err)
 		}
-		if err := k8s.ApplyKustomize("infra/postgres-operator"); err != nil {
-			log.Fatalw("failed to apply postgres-operator", "error", err)
+		var issuerCA []byte
+		if !namespaced {
+			if err := k8s.CreateNamespace("postgres-operator"); err != nil {
+				log.Fatalw("failed to create postgres-operator namespace", "error", err)
+			}
+			if err := k8s.ApplyKustomize(filepath.Join(repo, "postgres-operator")); err != nil {
+				log.Fatalw("failed to apply postgres-operator", "error", err)
+			}
+			if err := k8s.ApplyKustomize(filepath.Join(repo, "valkey-operator")); err != nil {
+				log.Fatalw("failed to apply valkey-operator", "error", err)
+			}
+			if err := k8s.ApplyKustomize(filepath.Join(repo, "gitea-operator")); err != nil {
+				log.Fatalw("failed to apply gitea-operator", "error", err)
+			}
+			issuer, err := issuerOptions(cmd)
+			if err != nil {
+				log.Fatalw("invalid issuer", "error", err)
+			}
+			// cert-manager's webhook has to come up before it accepts issuers
+			for tries := 0; ; tries++ {
+				if err = k8s.CreateIssuers(issuer); err == nil || tries >= 60 {
+					break
+				}
+				log.Warnw("failed to create issuers", "error", err, "try", tries)
+				time.Sleep(3 * time.Second)
+			}
+			if err != nil {
+				log.Fatalw("failed to create issuers", "error", err)
+			}
+			if err := k8s.WriteIssuersToFile(filepath.Join(repo, kubernetes.ISSUERS, "issuers.yaml")); err != nil {
+				log.Fatalw("failed to write issuers to file", "error", err)
+			}
+			if err := r.AddExisting(kubernetes.ISSUERS + "/issuers.yaml"); err != nil {
+				log.Fatalw("failed to add existing issuers", "error", err)
+			}
+			if err := r.GenerateKustomize(kubernetes.CertManager, kubernetes.ISSUERS); err != nil {
+				log.Fatalw("failed to generate kustomize", "error", err)
+			}
+			// Argo CD verifies Gitea, a Gateway the backends and Actions jobs
+			// Gitea against the pivot CA
+			switch issuer.Kind {
+			case kubernetes.CAIssuer:
+				issuerCA = issuer.CACert
+			case kubernetes.ACMEIssuer:
+			default:
+				if !dryRun {
+					if issuerCA, err = k8s.IssuerCA(); err != nil {
+						log.Fatalw("failed to read the issuer CA", "error", err)
+					}
+				}
+			}
 		}
-		if err := k8s.ApplyKustomize("infra/valkey-operator"); err != nil {
-			log.Fatalw("failed to apply valkey-operator", "error", err)
+		k8s.SetRepoCA(issuerCA)
+		remote := cmd.Flag("remote").Value.String()
+		argoHost := cmd.Flag("argocd-host").Value.String()
+		if argoHost == "" && !argoCore {
+			argoHost = "argocd." + remote
 		}
-		if err := k8s.ApplyKustomize("infra/gitea-operator"); err != nil {
-			log.Fatalw("failed to apply gitea-operator", "error", err)
+		if err := k8s.SetExpose(kubernetes.ExposeOptions{
+			Kind:             kubernetes.ExposeKind(cmd.Flag("expose").Value.String()),
+			ArgoHost:         argoHost,
+			IngressClass:     cmd.Flag("ingress-class").Value.String(),
+			Gateway:          cmd.Flag("gateway").Value.String(),
+			GatewayNamespace: cmd.Flag("gateway-namespace").Value.String(),
+			GatewayClass:     gatewayClass,
+			BackendCA:        issuerCA,
+		}); err != nil {
+			log.Fatalw("invalid expose", "error", err)
 		}
 		pass := cmd.Flag("password").Value.String()
 		if pass == "" {
@@ -58,13 +206,35 @@ var runCmd = &cobra.Command{
 				log.Fatalw("failed to generate password", "error", err)
 			}
 		}
-		remote := cmd.Flag("remote").Value.String()
 		user := cmd.Flag("user").Value.String()
-		valkey := cmd.Flag("valkey").Value.String() == "true"
-		if err := k8s.CreateGitea("", user, pass, remote, valkey); err != nil {
+		actions := cmd.Flag("actions").Value.String() == "true"
+		if actions {
+			k8s.AddComponent(kubernetes.ActionsComponent())
+		}
+		deployPass, err := randString(32)
+		if err != nil {
+			log.Fatalw("failed to generate deploy password", "error", err)
+		}
+		// a LimitRange in default also applies to workloads pivot does not own
+		var giteaRequests map[string]string
+		if cmd.Flag("default-requests").Value.String() == "true" {
+			giteaRequests = profile.Requests
+		}
+		if err := k8s.CreateGitea("", kubernetes.GiteaOptions{
+			User:             user,
+			Password:         pass,
+			DeployPassword:   deployPass,
+			Domain:           remote,
+			Valkey:           valkey,
+			Issuer:           kubernetes.IssuerName,
+			Actions:          actions,
+			Replicas:         profile.GiteaReplicas,
+			PostgresReplicas: profile.PostgresReplicas,
+			Requests:         giteaRequests,
+		}); err != nil {
 			log.Fatalw("failed to create gitea", "error", err)
 		}
-		if err := k8s.WriteGiteaToFile("infra/gitea/gitea.yaml"); err != nil {
+		if err := k8s.WriteGiteaToFile(filepath.Join(repo, "gitea", "gitea.yaml")); err != nil {
 			log.Fatalw("failed to write gitea to file", "error", err)
 		}
 		if err := r.AddExisting("gitea/gitea.yaml"); err != nil {
@@ -73,8 +243,20 @@ var runCmd = &cobra.Command{
 		if err := r.GenerateKustomize("default", "gitea"); err != nil {
 			log.Fatalw("failed to generate kustomize", "error", err)
 		}
+		if expose := k8s.Expose(); expose.Kind == kubernetes.GatewayExpose && expose.GatewayClass != "" {
+			if err := k8s.WriteGatewayToFile(filepath.Join(repo, kubernetes.GATEWAY, "gateway.yaml")); err != nil {
+				log.Fatalw("failed to write gateway to file", "error", err)
+			}
+			if err := r.AddExisting(kubernetes.GATEWAY + "/gateway.yaml"); err != nil {
+				log.Fatalw("failed to add existing gateway", "error", err)
+			}
+			if err := r.GenerateKustomize(expose.GatewayNamespace, kubernetes.GATEWAY); err != nil {
+				log.Fatalw("failed to generate kustomize", "error", err)
+			}
+		}
 
-		repoURL := "https://localhost:3000/infra/infra.git"
+		giteaURL := giteaAddress(inCluster)
+		repoURL := giteaURL + "/infra/infra.git"
 		if err := r.AddRemote("local", repoURL); err != nil {
 			log.Fatalw("failed to add remote", "error", err)
 		}
@@ -84,21 +266,8 @@ var runCmd = &cobra.Command{
 		}
 		if !dryRun {
 			failed := true
-			go func() {
-				forwarder, err := proxy.NewForwarder(ctx, log, cmd.Flag("context").Value.String())
-				if err != nil {
-					log.Fatalw("failed to create forwarder", "error", err)
-				}
-				if err := forwarder.ForwardPorts("", "", ""); err != nil {
-					log.Fatalw("failed to forward ports", "error", err)
-				}
-			}()
-			for tries := 0; tries < 60; tries++ {
-				_, err := http.Get("https://localhost:3000/api/healthz")
-				if err == nil {
-					break
-				}
-				time.Sleep(3 * time.Second)
+			if _, err := connectGitea(ctx, log, inCluster); err != nil {
+				log.Fatalw("failed to connect to gitea", "error", err)
 			}
 			for tries := 0; tries < 60; tries++ {
 				if err := r.PushBasic("local", user, pass); err != nil {
@@ -114,10 +283,88 @@ var runCmd = &cobra.Command{
 			}
 		}
 
-		if err := k8s.CreateArgoInit("", user, pass); err != nil {
+		if actions {
+			token := ""
+			if !dryRun {
+				token, err = gitea.NewClient(ctx, log, giteaURL, user, pass).RunnerRegistrationToken("infra")
+				if err != nil {
+					log.Fatalw("failed to get runner registration token", "error", err)
+				}
+			}
+			if err := k8s.CreateActionsRunner(token, remote, issuerCA); err != nil {
+				log.Fatalw("failed to create actions runner", "error", err)
+			}
+			if err := k8s.WriteActionsToFile(filepath.Join(repo, kubernetes.ACTIONS, "actions.yaml")); err != nil {
+				log.Fatalw("failed to write actions to file", "error", err)
+			}
+			if err := r.AddExisting(kubernetes.ACTIONS + "/actions.yaml"); err != nil {
+				log.Fatalw("failed to add existing actions", "error", err)
+			}
+			if err := r.GenerateKustomize(kubernetes.DEFAULT, kubernetes.ACTIONS); err != nil {
+				log.Fatalw("failed to generate kustomize", "error", err)
+			}
+			if err := r.AddValidateWorkflow(); err != nil {
+				log.Fatalw("failed to add validate workflow", "error", err)
+			}
+		}
+
+		if schedule := cmd.Flag("backup-schedule").Value.String(); schedule != "" {
+			k8s.AddComponent(kubernetes.BackupComponent())
+			s3 := s3Options(cmd)
+			image := "ghcr.io/hyperspike/pivot:latest"
+			if Version != "" {
+				image = "ghcr.io/hyperspike/pivot:" + Version
+			}
+			if err := k8s.CreateBackupSchedule(kubernetes.BackupOptions{
+				Schedule:    schedule,
+				To:          cmd.Flag("backup-to").Value.String(),
+				S3Endpoint:  s3.Endpoint,
+				S3Insecure:  s3.Insecure,
+				S3AccessKey: s3.AccessKey,
+				S3SecretKey: s3.SecretKey,
+				Image:       image,
+			}); err != nil {
+				log.Fatalw("failed to create backup schedule", "error", err)
+			}
+			if err := k8s.WriteBackupToFile(filepath.Join(repo, kubernetes.BACKUP, "backup.yaml")); err != nil {
+				log.Fatalw("failed to write backup to file", "error", err)
+			}
+			if err := r.AddExisting(kubernetes.BACKUP + "/backup.yaml"); err != nil {
+				log.Fatalw("failed to add existing backup", "error", err)
+			}
+			if err := r.GenerateKustomize(kubernetes.DEFAULT, kubernetes.BACKUP); err != nil {
+				log.Fatalw("failed to generate kustomize", "error", err)
+			}
+		}
+
+		if cmd.Flag("generator").Value.String() == string(kubernetes.GitGenerator) {
+			for _, c := range k8s.Components() {
+				params, err := c.Params()
+				if err != nil {
+					log.Fatalw("failed to build component config", "error", err, "component", c.Path)
+				}
+				if err := r.WriteComponentConfig(c.Path, params); err != nil {
+					log.Fatalw("failed to write component config", "error", err, "component", c.Path)
+				}
+			}
+		}
+		// Argo CD pulls with a read-only token of the deploy user, issued once
+		// the repository secret exists
+		if err := k8s.CreateArgoInit("", kubernetes.DeployUser, ""); err != nil {
 			log.Fatalw("failed to create argo init", "error", err)
 		}
-		if err := k8s.WriteArgoToFile("infra/init/init.yaml"); err != nil {
+		if !dryRun {
+			if err := rotateDeployToken(ctx, log, k8s, giteaURL); err != nil {
+				log.Fatalw("failed to create deploy token", "error", err)
+			}
+			// without the webhook Argo CD still polls the repo
+			if argoCore {
+				log.Info("Argo CD core polls the repo, not registering a webhook")
+			} else if err := registerWebhook(ctx, log, k8s, giteaURL, user, pass, cmd.Flag("webhook-url").Value.String()); err != nil {
+				log.Warnw("failed to register webhook", "error", err)
+			}
+		}
+		if err := k8s.WriteArgoToFile(filepath.Join(repo, "init", "init.yaml")); err != nil {
 			log.Fatalw("failed to write argo to file", "error", err)
 		}
 		if err := r.AddExisting("init/init.yaml"); err != nil {
@@ -137,9 +384,46 @@ var runCmd = &cobra.Command{
 			}
 		}
 
+		timeout, err := cmd.Flags().GetDuration("wait-timeout")
+		if err != nil {
+			log.Fatalw("failed to read wait timeout", "error", err)
+		}
+		if timeout > 0 {
+			statuses, err := k8s.WaitForApplications(timeout)
+			if err != nil {
+				for _, s := range statuses {
+					if !s.Ready() {
+						log.Errorw("application not ready", "application", s.Name, "sync", s.Sync, "health", s.Health, "messages", s.Messages)
+					}
+				}
+				log.Fatalw("handoff to Argo CD failed", "error", err)
+			}
+		}
 	},
 }
 
+// issuerOptions reads the issuer flags, loading a user supplied CA keypair.
+func issuerOptions(cmd *cobra.Command) (kubernetes.IssuerOptions, error) {
+	opts := kubernetes.IssuerOptions{
+		Kind:         kubernetes.IssuerKind(cmd.Flag("issuer").Value.String()),
+		ACMEServer:   cmd.Flag("acme-server").Value.String(),
+		ACMEEmail:    cmd.Flag("acme-email").Value.String(),
+		IngressClass: cmd.Flag("ingress-class").Value.String(),
+	}
+	opts.ACMESkipTLSVerify = cmd.Flag("acme-skip-tls-verify").Value.String() == "true"
+	if opts.Kind != kubernetes.CAIssuer {
+		return opts, nil
+	}
+	var err error
+	if opts.CACert, err = os.ReadFile(filepath.Clean(cmd.Flag("ca-cert").Value.String())); err != nil {
+		return opts, err
+	}
+	if opts.CAKey, err = os.ReadFile(filepath.Clean(cmd.Flag("ca-key").Value.String())); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
 func randString(n int) (string, error) {
 	const letters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
 	ret := make([]byte, n)
@@ -177,7 +461,7 @@ func init() {
 	if err := viper.BindPFlag("PIVOT_DRY_RUN", runCmd.Flags().Lookup("dry-run")); err != nil {
 		panic(err)
 	}
-	runCmd.Flags().StringP("namespace", "n", "", "namespace (context default if not set) [env PIVOT_NAMESPACE]")
+	runCmd.Flags().StringSliceP("namespace", "n", []string{}, "namespaces Argo CD manages with --namespaced besides argocd and those of the components and destinations [env PIVOT_NAMESPACE]")
 	if err := viper.BindPFlag("PIVOT_NAMESPACE", runCmd.Flags().Lookup("namespace")); err != nil {
 		panic(err)
 	}
@@ -185,4 +469,71 @@ func init() {
 	if err := viper.BindPFlag("PIVOT_VALKEY", runCmd.Flags().Lookup("valkey")); err != nil {
 		panic(err)
 	}
+	runCmd.Flags().String("repo", "infra", "path to create the infra repository in")
+	runCmd.Flags().Bool("actions", false, "enable Gitea Actions with a runner and a workflow validating the infra repo [env PIVOT_ACTIONS]")
+	if err := viper.BindPFlag("PIVOT_ACTIONS", runCmd.Flags().Lookup("actions")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().String("issuer", string(kubernetes.SelfSignedIssuer), "how certificates are issued, selfsigned, ca or acme [env PIVOT_ISSUER]")
+	if err := viper.BindPFlag("PIVOT_ISSUER", runCmd.Flags().Lookup("issuer")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().String("ca-cert", "", "PEM certificate of the ca issuer")
+	runCmd.Flags().String("ca-key", "", "PEM private key of the ca issuer, kept out of the repository")
+	runCmd.Flags().String("acme-server", kubernetes.LetsEncrypt, "ACME directory URL of the acme issuer")
+	runCmd.Flags().String("acme-email", "", "ACME account email of the acme issuer")
+	runCmd.Flags().Bool("acme-skip-tls-verify", false, "skip verifying the ACME server certificate, e.g. for a local Pebble")
+	runCmd.Flags().String("ingress-class", "", "ingress class of the Gitea and Argo CD ingresses and ACME challenges (cluster default if not set)")
+	runCmd.Flags().String("expose", string(kubernetes.NoExpose), "expose Gitea and Argo CD with none, ingress or gateway [env PIVOT_EXPOSE]")
+	if err := viper.BindPFlag("PIVOT_EXPOSE", runCmd.Flags().Lookup("expose")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().String("argocd-host", "", "hostname of Argo CD (argocd.<remote> if not set)")
+	runCmd.Flags().String("gateway", "pivot", "name of the Gateway routes attach to")
+	runCmd.Flags().String("gateway-namespace", "default", "namespace of the Gateway routes attach to")
+	runCmd.Flags().String("gateway-class", "", "create the Gateway with this GatewayClass instead of using an existing one")
+	runCmd.Flags().Duration("wait-timeout", 15*time.Minute, "how long to wait for Argo CD to report the platform synced and healthy, 0 to not wait")
+	runCmd.Flags().String("generator", string(kubernetes.ListGenerator), "how the init ApplicationSet finds components, list or git (every directory of the infra repo) [env PIVOT_GENERATOR]")
+	if err := viper.BindPFlag("PIVOT_GENERATOR", runCmd.Flags().Lookup("generator")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().StringSlice("exclude", []string{}, "directory patterns the git generator skips")
+	runCmd.Flags().String("webhook-url", "", "Argo CD webhook endpoint Gitea notifies of pushes, e.g. https://<argocd-host>/api/webhook (Argo CD polls if not set)")
+	runCmd.Flags().Bool("rolling-sync", false, "roll the components out wave by wave, their Applications are then synced by the ApplicationSet controller instead of automatically (without it the waves are not enforced)")
+	runCmd.Flags().StringSlice("destination", []string{}, "extra namespaces the platform AppProject may deploy to, * for any")
+	runCmd.Flags().String("profile", kubernetes.DefaultProfile, "the size of the platform, minimal (Argo CD core), standard or ha (3 nodes) [env PIVOT_PROFILE]")
+	if err := viper.BindPFlag("PIVOT_PROFILE", runCmd.Flags().Lookup("profile")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().Bool("default-requests", false, "give every container of the default namespace without requests, including Gitea's, those of the profile through a LimitRange")
+	runCmd.Flags().Bool("skip-preflight", false, "do not check the cluster before changing it")
+	runCmd.Flags().Bool("namespaced", false, "only use namespaced resources, for namespace admins of argocd, default and --namespace; cert-manager, the operators and the pivot ClusterIssuer must be installed [env PIVOT_NAMESPACED]")
+	if err := viper.BindPFlag("PIVOT_NAMESPACED", runCmd.Flags().Lookup("namespaced")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().String("backup-schedule", "", "cron schedule of an in-cluster pivot backup, none if not set [env PIVOT_BACKUP_SCHEDULE]")
+	if err := viper.BindPFlag("PIVOT_BACKUP_SCHEDULE", runCmd.Flags().Lookup("backup-schedule")); err != nil {
+		panic(err)
+	}
+	runCmd.Flags().String("backup-to", "", "s3://bucket/prefix/ scheduled backups are uploaded to")
+	addS3Flags(runCmd.Flags())
+	runCmd.Flags().Bool("in-cluster", false, "run from a pod, pushing to the Gitea Service instead of a port-forward (detected automatically) [env PIVOT_IN_CLUSTER]")
+	if err := viper.BindPFlag("PIVOT_IN_CLUSTER", runCmd.Flags().Lookup("in-cluster")); err != nil {
+		panic(err)
+	}
+}
+
+// repoOptions selects the Argo CD edition and container resources of
+// profile.
+func repoOptions(namespaced bool, profile kubernetes.Profile) (git.RepoOptions, error) {
+	resources, err := profile.ResourcePatch()
+	if err != nil {
+		return git.RepoOptions{}, err
+	}
+	return git.RepoOptions{
+		Namespaced: namespaced,
+		Profile:    profile.Name,
+		Argo:       profile.Argo,
+		Resources:  resources,
+	}, nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+type statusOutput struct {
+	*kubernetes.PlatformStatus
+	Head string `json:"head,omitempty"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the status of the bootstrapped platform",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		kube, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		status, err := kube.Status()
+		if err != nil {
+			log.Fatalw("failed to get status", "error", err)
+		}
+		out := statusOutput{PlatformStatus: status}
+		if r, err := git.OpenRepo(ctx, log, cmd.Flag("repo").Value.String()); err == nil {
+			if out.Head, err = r.Head(); err != nil {
+				log.Warnw("failed to read repo HEAD", "error", err)
+			}
+		}
+		switch cmd.Flag("output").Value.String() {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(out); err != nil {
+				log.Fatalw("failed to encode status", "error", err)
+			}
+		case "table":
+			printStatus(out)
+		default:
+			log.Fatalw("unknown output format", "output", cmd.Flag("output").Value.String())
+		}
+	},
+}
+
+func printStatus(out statusOutput) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tVERSION\tWORKLOADS\tSYNC\tHEALTH\tREVISION")
+	for _, c := range out.Components {
+		ready := 0
+		for _, wl := range c.Workloads {
+			if wl.Ready >= wl.Desired {
+				ready++
+			}
+		}
+		revision := shortRevision(c.SyncedRevision)
+		if c.Behind(out.Head) {
+			revision += " (HEAD " + shortRevision(out.Head) + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\t%s\n",
+			c.Component, orDash(c.Version), ready, len(c.Workloads), orDash(c.Sync), orDash(c.Health), orDash(revision))
+	}
+	_ = w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tNAMESPACE\tNAME\tSTATUS\tMESSAGE")
+	if out.Gitea != nil {
+		fmt.Fprintf(w, "Gitea\t%s\t%s\t%s\t%s\n", out.Gitea.Namespace, out.Gitea.Name, out.Gitea.Status, out.Gitea.Message)
+	}
+	for _, pg := range out.Postgres {
+		fmt.Fprintf(w, "Postgres\t%s\t%s\t%s\t%s\n", pg.Namespace, pg.Name, pg.Status, pg.Message)
+	}
+	_ = w.Flush()
+}
+
+func shortRevision(rev string) string {
+	if len(rev) > 7 && !strings.Contains(rev, "/") {
+		return rev[:7]
+	}
+	return rev
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	viper.AutomaticEnv()
+	statusCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	statusCmd.Flags().String("repo", "infra", "path to the local infra repository [env PIVOT_REPO]")
+	if err := viper.BindPFlag("PIVOT_REPO", statusCmd.Flags().Lookup("repo")); err != nil {
+		panic(err)
+	}
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+	"hyperspike.io/pivot/internal/support"
+)
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "collect the state of the platform into an archive to attach to issues",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.TODO()
+		log := getLogger(cmd)
+		k8s, err := kubernetes.NewK8s(ctx, log, kubeFlags, false)
+		if err != nil {
+			log.Fatalw("failed to create k8s", "error", err)
+		}
+		opts := support.Options{
+			Version: Version,
+			Commit:  Commit,
+			Config:  viper.AllSettings(),
+			Commits: 50,
+		}
+		if opts.Since, err = cmd.Flags().GetDuration("since"); err != nil {
+			log.Fatalw("failed to read since", "error", err)
+		}
+		if opts.Tail, err = cmd.Flags().GetInt64("tail"); err != nil {
+			log.Fatalw("failed to read tail", "error", err)
+		}
+		if r, err := git.OpenRepo(ctx, log, cmd.Flag("repo").Value.String()); err == nil {
+			opts.Repo = r
+		} else {
+			log.Warnw("leaving out the repository log", "error", err)
+		}
+		out := cmd.Flag("output").Value.String()
+		if out == "" {
+			out = "pivot-support-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+		}
+		f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalw("failed to create support bundle", "error", err, "output", out)
+		}
+		if err := support.Create(ctx, log, k8s, f, opts); err != nil {
+			_ = f.Close()
+			log.Fatalw("failed to create support bundle", "error", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalw("failed to write support bundle", "error", err, "output", out)
+		}
+		log.Infow("Support bundle written, pod logs are masked by pattern only, check them before sharing", "output", out)
+	},
+}
+
+func init() {
+	supportBundleCmd.Flags().StringP("output", "o", "", "path of the archive (pivot-support-<time>.tar.gz if not set)")
+	supportBundleCmd.Flags().String("repo", "infra", "path of the infra repository")
+	supportBundleCmd.Flags().Duration("since", time.Hour, "how far back to collect events")
+	supportBundleCmd.Flags().Int64("tail", 500, "log lines to collect per container")
+	rootCmd.AddCommand(supportBundleCmd)
+}
@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ManifestFile describes the archive
+	ManifestFile = "manifest.json"
+	// DumpFile is the output of gitea dump, the database, config and
+	// repositories of the instance
+	DumpFile = "gitea-dump.tar.gz"
+	// RepositoriesFile is a tar of a git bundle per repository, named
+	// <owner>/<repo>.bundle
+	RepositoriesFile = "repositories.tar"
+)
+
+// dumpCommand writes a gitea dump to stdout, gitea refuses to run from a
+// directory it cannot write its temporary files to.
+var dumpCommand = []string{"sh", "-c", "cd /tmp && gitea dump --type tar.gz --skip-log --file -"}
+
+// bundleCommand writes a tar of a bundle of every repository to stdout,
+// covering the repository roots of both the rootful and rootless images.
+// Repositories without refs cannot be bundled and are skipped, any other
+// failure fails the backup.
+var bundleCommand = []string{"sh", "-c", `set -e
+tmp=$(mktemp -d)
+for root in /data/git/gitea-repositories /var/lib/gitea/git/repositories; do
+  [ -d "$root" ] || continue
+  cd "$root"
+  for repo in */*.git; do
+    [ -d "$repo" ] || continue
+    if [ -z "$(git --git-dir="$repo" for-each-ref --count=1)" ]; then
+      echo "skipping empty $repo" >&2
+      continue
+    fi
+    mkdir -p "$tmp/${repo%/*}"
+    git --git-dir="$repo" bundle create "$tmp/${repo%.git}.bundle" --all >&2
+  done
+done
+tar -C "$tmp" -cf - .
+rm -rf "$tmp"`}
+
+// Options selects the Gitea pod to back up.
+type Options struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// Manifest records where and when a backup was taken.
+type Manifest struct {
+	Created   time.Time `json:"created"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Files     []string  `json:"files"`
+}
+
+// Executor runs a command in a container, as kubernetes.K8s does.
+type Executor interface {
+	Exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// Create takes a gitea dump and a bundle of every repository from the Gitea
+// pod and writes them to w as a gzipped tar. Nothing is written to w unless
+// both were taken.
+func Create(ctx context.Context, log *zap.SugaredLogger, k Executor, w io.Writer, opts Options) error {
+	log = log.Named("backup").With("pod", opts.Namespace+"/"+opts.Pod)
+	tmp, err := os.MkdirTemp("", "pivot-backup-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmp); err != nil {
+			log.Errorw("failed to remove temporary directory", "error", err)
+		}
+	}()
+
+	// tar needs the size of every entry up front, so the streams are
+	// spooled to disk first
+	files := []struct {
+		name    string
+		command []string
+	}{
+		{DumpFile, dumpCommand},
+		{RepositoriesFile, bundleCommand},
+	}
+	manifest := Manifest{Created: time.Now().UTC(), Namespace: opts.Namespace, Pod: opts.Pod}
+	for _, f := range files {
+		log.Infow("Streaming", "file", f.name)
+		out, err := os.Create(tmp + "/" + f.name)
+		if err != nil {
+			return err
+		}
+		err = k.Exec(opts.Namespace, opts.Pod, opts.Container, f.command, nil, out, &logWriter{log: log})
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, f.name)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: ManifestFile, Mode: 0600, Size: int64(len(data)), ModTime: manifest.Created}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, name := range manifest.Files {
+		if err := addFile(tw, tmp, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	log.Infow("Backup complete", "files", manifest.Files)
+	return nil
+}
+
+func addFile(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(dir + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// logWriter logs the stderr of the backup commands line by line.
+type logWriter struct {
+	log *zap.SugaredLogger
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.log.Debugw("remote", "output", string(p))
+	return len(p), nil
+}
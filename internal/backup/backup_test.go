@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeExec answers the dump and bundle commands with canned output, failing
+// the command containing fail.
+type fakeExec struct {
+	fail     string
+	commands []string
+}
+
+func (f *fakeExec) Exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	script := command[len(command)-1]
+	f.commands = append(f.commands, namespace+"/"+pod+"/"+container)
+	if f.fail != "" && strings.Contains(script, f.fail) {
+		_, _ = io.WriteString(stdout, "partial")
+		return fmt.Errorf("command terminated with exit code 1")
+	}
+	if strings.Contains(script, "gitea dump") {
+		_, _ = io.WriteString(stdout, "dump")
+	} else {
+		_, _ = io.WriteString(stdout, "bundles")
+	}
+	_, _ = io.WriteString(stderr, "progress\n")
+	return nil
+}
+
+func TestCreate(t *testing.T) {
+	exec := &fakeExec{}
+	buf := &bytes.Buffer{}
+	if err := Create(context.Background(), zap.NewNop().Sugar(), exec, buf, Options{Namespace: "default", Pod: "gitea-0", Container: "gitea"}); err != nil {
+		t.Fatalf("Create failed %v", err)
+	}
+	if len(exec.commands) != 2 || exec.commands[0] != "default/gitea-0/gitea" {
+		t.Errorf("Expected both commands in the gitea container, got %v", exec.commands)
+	}
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatalf("Expected a gzipped archive %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Expected a valid tar %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != ManifestFile+","+DumpFile+","+RepositoriesFile {
+		t.Errorf("Expected the manifest followed by both streams, got %v", names)
+	}
+	if files[DumpFile] != "dump" || files[RepositoriesFile] != "bundles" {
+		t.Errorf("Expected both streams to be spooled intact, got %v", files)
+	}
+	manifest := Manifest{}
+	if err := json.Unmarshal([]byte(files[ManifestFile]), &manifest); err != nil {
+		t.Fatalf("Expected a JSON manifest %v", err)
+	}
+	if manifest.Namespace != "default" || manifest.Pod != "gitea-0" || manifest.Created.IsZero() ||
+		strings.Join(manifest.Files, ",") != DumpFile+","+RepositoriesFile {
+		t.Errorf("Expected the manifest to describe the backup, got %+v", manifest)
+	}
+}
+
+func TestCreateExecError(t *testing.T) {
+	for _, fail := range []string{"gitea dump", "bundle create"} {
+		buf := &bytes.Buffer{}
+		if err := Create(context.Background(), zap.NewNop().Sugar(), &fakeExec{fail: fail}, buf, Options{Namespace: "default", Pod: "gitea-0"}); err == nil {
+			t.Errorf("Expected a failing %s to fail the backup", fail)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Expected no archive when %s fails, got %d bytes", fail, buf.Len())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractRepository extracts the bare repository owner/repo from the gitea
+// dump of the backup archive read from r into dest.
+func ExtractRepository(r io.Reader, owner, repo, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("no %s in the backup archive", DumpFile)
+		} else if err != nil {
+			return err
+		}
+		if hdr.Name == DumpFile {
+			return extractDumpRepository(tr, owner, repo, dest)
+		}
+	}
+}
+
+// extractDumpRepository extracts repos/<owner>/<repo>.git of a gitea dump.
+func extractDumpRepository(r io.Reader, owner, repo, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	// gitea lower cases repository paths on disk
+	prefix := "repos/" + strings.ToLower(owner) + "/" + strings.ToLower(repo) + ".git/"
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		found = true
+		rel := filepath.Clean(strings.TrimPrefix(name, prefix))
+		if rel == "." {
+			continue
+		}
+		if strings.HasPrefix(rel, "..") || filepath.IsAbs(rel) {
+			return fmt.Errorf("invalid path %s in the gitea dump", hdr.Name)
+		}
+		target := filepath.Join(dest, rel)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no repository %s/%s in the gitea dump", owner, repo)
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil { // #nosec G110 -- the archive is our own backup
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
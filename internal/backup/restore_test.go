@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarGz builds a tar.gz of files, keyed by name.
+func tarGz(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractRepository(t *testing.T) {
+	dump := tarGz(t, map[string][]byte{
+		"repos/infra/infra.git/HEAD":              []byte("ref: refs/heads/main\n"),
+		"./repos/infra/infra.git/refs/heads/main": []byte("0000\n"),
+		"repos/infra/other.git/HEAD":              []byte("ref: refs/heads/main\n"),
+		"gitea-db.sql":                            []byte("--"),
+	})
+	archive := tarGz(t, map[string][]byte{DumpFile: dump})
+
+	dest := t.TempDir()
+	if err := ExtractRepository(bytes.NewReader(archive), "infra", "infra", dest); err != nil {
+		t.Fatalf("ExtractRepository failed %v", err)
+	}
+	if head, err := os.ReadFile(filepath.Join(dest, "HEAD")); err != nil || string(head) != "ref: refs/heads/main\n" {
+		t.Errorf("Expected HEAD to be extracted, got %q %v", head, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "refs", "heads", "main")); err != nil {
+		t.Errorf("Expected refs to be extracted, got %v", err)
+	}
+
+	if err := ExtractRepository(bytes.NewReader(archive), "infra", "missing", t.TempDir()); err == nil {
+		t.Errorf("Expected an error for a repository not in the dump")
+	}
+	if err := ExtractRepository(bytes.NewReader(tarGz(t, map[string][]byte{})), "infra", "infra", t.TempDir()); err == nil {
+		t.Errorf("Expected an error for an archive without a dump")
+	}
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options configures access to an S3 compatible store, such as MinIO.
+type S3Options struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	// Insecure talks plain HTTP to the endpoint
+	Insecure bool
+}
+
+// Name returns the archive name of a backup taken at t.
+func Name(t time.Time) string {
+	return "pivot-backup-" + t.UTC().Format("20060102-150405") + ".tar.gz"
+}
+
+// Resolve appends a timestamped archive name to dest when it names a
+// directory or bucket prefix, i.e. ends with a slash.
+func Resolve(dest string, t time.Time) string {
+	if strings.HasSuffix(dest, "/") {
+		return dest + Name(t)
+	}
+	return dest
+}
+
+// parseS3 splits s3://bucket/key, ok is false for anything else.
+func parseS3(dest string) (bucket, key string, ok bool, err error) {
+	if !strings.HasPrefix(dest, "s3://") {
+		return "", "", false, nil
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return "", "", true, fmt.Errorf("expected s3://bucket/key, got %s", dest)
+	}
+	return bucket, key, true, nil
+}
+
+func newS3Client(opts S3Options) (*minio.Client, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("an s3 endpoint is required")
+	}
+	return minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+}
+
+// Target is an archive being written, Close commits it and Abort discards
+// it, leaving any previous archive at the destination untouched.
+type Target interface {
+	io.WriteCloser
+	Abort(err error)
+}
+
+// OpenTarget opens dest for writing an archive, either a local path or
+// s3://bucket/key.
+func OpenTarget(ctx context.Context, dest string, opts S3Options) (Target, error) {
+	bucket, key, isS3, err := parseS3(dest)
+	if err != nil {
+		return nil, err
+	}
+	if !isS3 {
+		dest = filepath.Clean(dest)
+		dir := filepath.Dir(dest)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
+		// the archive is renamed into place once complete
+		f, err := os.CreateTemp(dir, "."+filepath.Base(dest)+"-*")
+		if err != nil {
+			return nil, err
+		}
+		return &fileWriter{File: f, dest: dest}, nil
+	}
+	client, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.PutObject(ctx, bucket, key, r, -1, minio.PutObjectOptions{ContentType: "application/gzip"})
+		_ = r.CloseWithError(err)
+		done <- err
+	}()
+	return &s3Writer{PipeWriter: w, done: done}, nil
+}
+
+// fileWriter writes to a temporary file next to dest, Close renames it to
+// dest.
+type fileWriter struct {
+	*os.File
+	dest string
+}
+
+func (f *fileWriter) Close() error {
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), f.dest)
+}
+
+func (f *fileWriter) Abort(error) {
+	_ = f.File.Close()
+	_ = os.Remove(f.Name())
+}
+
+// s3Writer uploads everything written to it, Close waits for the upload to
+// complete.
+type s3Writer struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (s *s3Writer) Close() error {
+	if err := s.PipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-s.done
+}
+
+// Abort fails the upload with err so no object is written.
+func (s *s3Writer) Abort(err error) {
+	_ = s.PipeWriter.CloseWithError(err)
+	<-s.done
+}
+
+// OpenSource opens the archive at src for reading, either a local path or
+// s3://bucket/key.
+func OpenSource(ctx context.Context, src string, opts S3Options) (io.ReadCloser, error) {
+	bucket, key, isS3, err := parseS3(src)
+	if err != nil {
+		return nil, err
+	}
+	if !isS3 {
+		return os.Open(filepath.Clean(src))
+	}
+	client, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+}
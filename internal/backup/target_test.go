@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Stub is an in-memory S3 endpoint serving single and multipart uploads
+// and downloads, failing every write with deny.
+type s3Stub struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string][]byte
+	deny    bool
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	if s.deny && r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		s.parts[r.URL.Path] = append(s.parts[r.URL.Path], payload(r)...)
+		w.Header().Set("ETag", `"part"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.objects[r.URL.Path] = s.parts[r.URL.Path]
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"object"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+	case r.Method == http.MethodPut:
+		s.objects[r.URL.Path] = payload(r)
+		w.Header().Set("ETag", `"object"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("ETag", `"object"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// payload reads the body of an upload, decoding the chunks minio-go signs
+// over plain HTTP.
+func payload(r *http.Request) []byte {
+	data, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data
+	}
+	decoded := []byte{}
+	for len(data) > 0 {
+		header, rest, _ := strings.Cut(string(data), "\r\n")
+		size, _, _ := strings.Cut(header, ";")
+		n := 0
+		if _, err := fmt.Sscanf(size, "%x", &n); err != nil || n == 0 {
+			break
+		}
+		decoded = append(decoded, rest[:n]...)
+		data = []byte(strings.TrimPrefix(rest[n:], "\r\n"))
+	}
+	return decoded
+}
+
+func newS3Stub(t *testing.T, deny bool) (*s3Stub, S3Options) {
+	t.Helper()
+	stub := &s3Stub{objects: map[string][]byte{}, parts: map[string][]byte{}, deny: deny}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, S3Options{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+		Insecure:  true,
+	}
+}
+
+func TestS3Target(t *testing.T) {
+	stub, opts := newS3Stub(t, false)
+	w, err := OpenTarget(context.Background(), "s3://backups/pivot.tar.gz", opts)
+	if err != nil {
+		t.Fatalf("OpenTarget failed %v", err)
+	}
+	if _, err := io.WriteString(w, "archive"); err != nil {
+		t.Fatalf("Write failed %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Expected the upload to complete on Close, got %v", err)
+	}
+	if got := string(stub.objects["/backups/pivot.tar.gz"]); got != "archive" {
+		t.Errorf("Expected the archive to be uploaded, got %q", got)
+	}
+
+	r, err := OpenSource(context.Background(), "s3://backups/pivot.tar.gz", opts)
+	if err != nil {
+		t.Fatalf("OpenSource failed %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "archive" {
+		t.Errorf("Expected the archive to be read back, got %q %v", data, err)
+	}
+}
+
+func TestS3TargetCloseError(t *testing.T) {
+	_, opts := newS3Stub(t, true)
+	w, err := OpenTarget(context.Background(), "s3://backups/pivot.tar.gz", opts)
+	if err != nil {
+		t.Fatalf("OpenTarget failed %v", err)
+	}
+	// writes fail once the upload is rejected, Close reports why
+	_, _ = io.WriteString(w, "archive")
+	err = w.Close()
+	if err == nil || !strings.Contains(err.Error(), "Access Denied") {
+		t.Errorf("Expected the rejected upload to fail Close, got %v", err)
+	}
+}
+
+func TestS3TargetAbort(t *testing.T) {
+	stub, opts := newS3Stub(t, false)
+	stub.objects["/backups/pivot.tar.gz"] = []byte("previous")
+	w, err := OpenTarget(context.Background(), "s3://backups/pivot.tar.gz", opts)
+	if err != nil {
+		t.Fatalf("OpenTarget failed %v", err)
+	}
+	_, _ = io.WriteString(w, "partial")
+	w.Abort(fmt.Errorf("dump failed"))
+	if got := string(stub.objects["/backups/pivot.tar.gz"]); got != "previous" {
+		t.Errorf("Expected an aborted upload to leave the previous archive, got %q", got)
+	}
+}
+
+func TestFileTarget(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "backups", "pivot.tar.gz")
+	w, err := OpenTarget(context.Background(), dest, S3Options{})
+	if err != nil {
+		t.Fatalf("OpenTarget failed %v", err)
+	}
+	_, _ = io.WriteString(w, "archive")
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("Expected the archive to appear only once complete, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+
+	w, err = OpenTarget(context.Background(), dest, S3Options{})
+	if err != nil {
+		t.Fatalf("OpenTarget failed %v", err)
+	}
+	_, _ = io.WriteString(w, "partial")
+	w.Abort(fmt.Errorf("dump failed"))
+	if data, err := os.ReadFile(dest); err != nil || string(data) != "archive" {
+		t.Errorf("Expected an aborted backup to leave the previous archive, got %q %v", data, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dest)); len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestOpenTargetWithoutEndpoint(t *testing.T) {
+	if _, err := OpenTarget(context.Background(), "s3://backups/pivot.tar.gz", S3Options{}); err == nil {
+		t.Errorf("Expected an S3 target without an endpoint to be rejected")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]string{
+		"backup.tar.gz":        "backup.tar.gz",
+		"backups/":             "backups/pivot-backup-20260102-030405.tar.gz",
+		"s3://bucket/prefix/":  "s3://bucket/prefix/pivot-backup-20260102-030405.tar.gz",
+		"s3://bucket/a.tar.gz": "s3://bucket/a.tar.gz",
+	}
+	for dest, want := range cases {
+		if got := Resolve(dest, now); got != want {
+			t.Errorf("Resolve(%q) = %q, expected %q", dest, got, want)
+		}
+	}
+}
+
+func TestParseS3(t *testing.T) {
+	bucket, key, ok, err := parseS3("s3://bucket/prefix/a.tar.gz")
+	if err != nil || !ok || bucket != "bucket" || key != "prefix/a.tar.gz" {
+		t.Errorf("Expected bucket and key, got %q %q %v %v", bucket, key, ok, err)
+	}
+	if _, _, ok, err := parseS3("backup.tar.gz"); ok || err != nil {
+		t.Errorf("Expected a local path to not be S3, got %v %v", ok, err)
+	}
+	if _, _, ok, err := parseS3("s3://bucket"); !ok || err == nil {
+		t.Errorf("Expected an error for a missing key, got %v %v", ok, err)
+	}
+}
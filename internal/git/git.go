@@ -3,6 +3,7 @@ package git
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -23,8 +24,10 @@ type Spool struct {
 	Repo   *git.Repository
 	Path   string
 	Remote string
-	ctx    context.Context
-	log    *zap.SugaredLogger
+	// Versions holds the upstream release of each component, keyed by path
+	Versions map[string]string
+	ctx      context.Context
+	log      *zap.SugaredLogger
 }
 
 var (
@@ -32,6 +35,107 @@ var (
 	Name  = "Pivot GitOps"
 )
 
+// ComponentConfig holds a component's Application overrides, it is read by
+// the git generator of the init ApplicationSet rather than kustomize.
+const ComponentConfig = "pivot.json"
+
+// progressiveSyncs enables the RollingSync strategy of the init ApplicationSet.
+const progressiveSyncs = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: argocd-cmd-params-cm
+data:
+  applicationsetcontroller.enable.progressive.syncs: "true"
+`
+
+// certManagerGatewayAPI enables cert-manager's Gateway API support, which
+// is off by default, on its controller.
+const certManagerGatewayAPI = `- op: add
+  path: /spec/template/spec/containers/0/args/-
+  value: --enable-gateway-api
+`
+
+// upstream sources CreateRepo fetches the components from
+const (
+	argoManifests            = "https://raw.githubusercontent.com/argoproj/argo-cd/refs/heads/master/manifests/"
+	certManagerReleases      = "https://api.github.com/repos/cert-manager/cert-manager/releases/latest"
+	valkeyOperatorReleases   = "https://api.github.com/repos/hyperspike/valkey-operator/releases/latest"
+	postgresOperatorReleases = "https://api.github.com/repos/zalando/postgres-operator/releases/latest"
+	postgresOperatorRepo     = "https://github.com/zalando/postgres-operator"
+	giteaOperatorReleases    = "https://api.github.com/repos/hyperspike/gitea-operator/releases/latest"
+)
+
+// Argo CD editions, the manifests CreateRepo installs Argo CD from
+const (
+	// ArgoCore runs without the UI, API server and dex
+	ArgoCore     = "core"
+	ArgoStandard = "standard"
+	// ArgoHA runs replicated controllers and redis, on at least 3 nodes
+	ArgoHA = "ha"
+)
+
+// argoInstall returns the Argo CD manifest of opts. Core has no namespaced
+// variant, namespaced it falls back to the standard install.
+func argoInstall(opts RepoOptions) string {
+	switch {
+	case opts.Argo == ArgoHA && opts.Namespaced:
+		return argoManifests + "ha/namespace-install.yaml"
+	case opts.Argo == ArgoHA:
+		return argoManifests + "ha/install.yaml"
+	case opts.Namespaced:
+		return argoManifests + "namespace-install.yaml"
+	case opts.Argo == ArgoCore:
+		return argoManifests + "core-install.yaml"
+	}
+	return argoManifests + "install.yaml"
+}
+
+// Sources returns the upstream URLs CreateRepo fetches from with opts.
+func Sources(opts RepoOptions) []string {
+	if opts.Namespaced {
+		return []string{argoInstall(opts)}
+	}
+	return []string{
+		argoInstall(opts),
+		certManagerReleases,
+		valkeyOperatorReleases,
+		postgresOperatorReleases,
+		postgresOperatorRepo,
+		giteaOperatorReleases,
+	}
+}
+
+// githubAPI is the host of the GitHub releases API, which rate limits
+// unauthenticated requests per address.
+const githubAPI = "api.github.com"
+
+// ErrRateLimited is returned by CheckSource when the GitHub API refuses a
+// request for the rate limit, which says nothing about whether the source
+// can be fetched later.
+var ErrRateLimited = errors.New("rate limited by " + githubAPI)
+
+// CheckSource verifies url can be fetched.
+func CheckSource(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if req.URL.Host == githubAPI && (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests) {
+		return fmt.Errorf("%s: %s: %w", url, res.Status, ErrRateLimited)
+	}
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	return nil
+}
+
 func RepoExists(path string) bool {
 	exists := false
 	_, err := git.PlainOpen(path)
@@ -41,8 +145,90 @@ func RepoExists(path string) bool {
 	return exists
 }
 
+// OpenRepo opens an existing repository created by CreateRepo
+func OpenRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spool, error) {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	log = log.Named("git").With("path", path)
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		log.Errorw("failed to open git repo", "error", err)
+		return nil, err
+	}
+	return &Spool{
+		Path:     path,
+		Repo:     repo,
+		Versions: map[string]string{},
+		ctx:      ctx,
+		log:      log,
+	}, nil
+}
+
+// CloneRepo clones the repository at url into path, e.g. one restored from
+// a backup. Every branch of url becomes a local branch so it can be pushed
+// as is, and the origin remote is removed.
+func CloneRepo(ctx context.Context, log *zap.SugaredLogger, url, path string) (*Spool, error) {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	log = log.Named("git").With("path", path)
+	repo, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{URL: url})
+	if err != nil {
+		log.Errorw("failed to clone git repo", "error", err, "url", url)
+		return nil, err
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsRemote() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		branch := plumbing.NewBranchReferenceName(strings.TrimPrefix(ref.Name().Short(), "origin/"))
+		if _, err := repo.Reference(branch, false); err == nil {
+			return nil
+		}
+		return repo.Storer.SetReference(plumbing.NewHashReference(branch, ref.Hash()))
+	})
+	if err != nil {
+		log.Errorw("failed to create branches", "error", err)
+		return nil, err
+	}
+	if err := repo.DeleteRemote("origin"); err != nil {
+		return nil, err
+	}
+	return &Spool{
+		Path:     path,
+		Repo:     repo,
+		Versions: map[string]string{},
+		ctx:      ctx,
+		log:      log,
+	}, nil
+}
+
+// RepoOptions selects what CreateRepo adds to the repository.
+type RepoOptions struct {
+	// Namespaced installs Argo CD scoped to its namespace, and leaves out
+	// cert-manager and the operators as a cluster admin installs them
+	Namespaced bool
+	// Profile is recorded in the README of the repository
+	Profile string
+	// Argo is the Argo CD edition, ArgoStandard when empty
+	Argo string
+	// Resources is a JSON patch setting the resources of the containers,
+	// applied to every workload of Argo CD, cert-manager and the operators
+	Resources string
+	// RollingSync enables Argo CD's progressive syncs
+	RollingSync bool
+	// GatewayAPI lets cert-manager issue the certificates of Gateway
+	// listeners
+	GatewayAPI bool
+}
+
 // Create a new git repository and adds the initial GitOps tooling
-func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spool, error) {
+func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string, opts RepoOptions) (*Spool, error) {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
@@ -58,28 +244,43 @@ func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spoo
 		return nil, err
 	}
 	s := &Spool{
-		Path: path,
-		Repo: repo,
-		ctx:  ctx,
-		log:  log,
+		Path:     path,
+		Repo:     repo,
+		Versions: map[string]string{"argocd": "master"},
+		ctx:      ctx,
+		log:      log,
 	}
-	if err = s.readme(); err != nil {
+	if err = s.readme(opts.Profile); err != nil {
 		return nil, err
 	}
 	if err = s.addUrl(
-		"https://raw.githubusercontent.com/argoproj/argo-cd/refs/heads/master/manifests/install.yaml",
+		argoInstall(opts),
 		"argocd/argocd.yaml",
 		"adding argo-cd"); err != nil {
 		return nil, err
 	}
-	if err = s.addNamespace("argocd", "adding argo-cd namespace"); err != nil {
-		s.log.Errorw("failed to add argo-cd namespace", "error", err)
-		return nil, err
+	// a namespace admin cannot create the namespace, it has to exist
+	if !opts.Namespaced {
+		if err = s.addNamespace("argocd", "adding argo-cd namespace"); err != nil {
+			s.log.Errorw("failed to add argo-cd namespace", "error", err)
+			return nil, err
+		}
 	}
 	if err = s.createKustomization("argocd", "adding argo-cd kustomization"); err != nil {
 		return nil, err
 	}
-	l, err := getLatest("https://api.github.com/repos/cert-manager/cert-manager/releases/latest")
+	if err = s.addResources("argocd", opts.Resources); err != nil {
+		return nil, err
+	}
+	if opts.RollingSync {
+		if err = s.addPatch("argocd", "progressive-syncs.yaml", progressiveSyncs, "", "enabling argo-cd progressive syncs"); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Namespaced {
+		return s, nil
+	}
+	l, err := getLatest(certManagerReleases)
 	if err != nil {
 		return nil, err
 	}
@@ -89,10 +290,19 @@ func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spoo
 		"adding cert-manager"); err != nil {
 		return nil, err
 	}
+	s.Versions["cert-manager"] = l
 	if err = s.createKustomization("cert-manager", "adding cert-manager kustomization"); err != nil {
 		return nil, err
 	}
-	l, err = getLatest("https://api.github.com/repos/hyperspike/valkey-operator/releases/latest")
+	if err = s.addResources("cert-manager", opts.Resources); err != nil {
+		return nil, err
+	}
+	if opts.GatewayAPI {
+		if err = s.addPatch("cert-manager", "gateway-api.yaml", certManagerGatewayAPI, "Deployment/cert-manager", "enabling cert-manager gateway api support"); err != nil {
+			return nil, err
+		}
+	}
+	l, err = getLatest(valkeyOperatorReleases)
 	if err != nil {
 		return nil, err
 	}
@@ -102,15 +312,19 @@ func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spoo
 		"adding valkey-operator"); err != nil {
 		return nil, err
 	}
+	s.Versions["valkey-operator"] = l
 	if err = s.createKustomization("valkey-operator", "adding valkey kustomization"); err != nil {
 		return nil, err
 	}
-	l, err = getLatest("https://api.github.com/repos/zalando/postgres-operator/releases/latest")
+	if err = s.addResources("valkey-operator", opts.Resources); err != nil {
+		return nil, err
+	}
+	l, err = getLatest(postgresOperatorReleases)
 	if err != nil {
 		return nil, err
 	}
 	if err := s.cloneTag(
-		"https://github.com/zalando/postgres-operator",
+		postgresOperatorRepo,
 		"postgres-operator",
 		l,
 	); err != nil {
@@ -128,10 +342,14 @@ func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spoo
 		"adding postgres-operator"); err != nil {
 		return nil, err
 	}
+	s.Versions["postgres-operator"] = l
 	if err = s.createKustomization("postgres-operator", "adding postgres kustomization"); err != nil {
 		return nil, err
 	}
-	l, err = getLatest("https://api.github.com/repos/hyperspike/gitea-operator/releases/latest")
+	if err = s.addResources("postgres-operator", opts.Resources); err != nil {
+		return nil, err
+	}
+	l, err = getLatest(giteaOperatorReleases)
 	if err != nil {
 		return nil, err
 	}
@@ -141,20 +359,28 @@ func CreateRepo(ctx context.Context, log *zap.SugaredLogger, path string) (*Spoo
 		"adding gitea-operator"); err != nil {
 		return nil, err
 	}
+	s.Versions["gitea-operator"] = l
 	if err = s.createKustomization("gitea-operator", "adding gitea kustomization"); err != nil {
 		return nil, err
 	}
+	if err = s.addResources("gitea-operator", opts.Resources); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
-// Add a README.md file to the repository
-func (s *Spool) readme() error {
+// Add a README.md file to the repository, recording the profile
+func (s *Spool) readme(profile string) error {
 	w, err := s.Repo.Worktree()
 	if err != nil {
 		return err
 	}
+	readme := "# Pivot GitOps"
+	if profile != "" {
+		readme += "\n\nDeployed with the `" + profile + "` profile.\n"
+	}
 	f := filepath.Join(s.Path, "README.md")
-	if err = os.WriteFile(f, []byte("# Pivot GitOps"), 0600); err != nil {
+	if err = os.WriteFile(f, []byte(readme), 0600); err != nil {
 		s.log.Errorw("failed to write README.md", "error", err)
 		return err
 	}
@@ -189,6 +415,39 @@ func (s *Spool) commit(msg string) error {
 	return nil
 }
 
+// Head returns the commit hash the repository currently points at.
+func (s *Spool) Head() (string, error) {
+	ref, err := s.Repo.Head()
+	if err != nil {
+		s.log.Errorw("failed to get HEAD", "error", err)
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+// Log returns the last max commits from HEAD, newest first, one line each
+// with the hash, date, author and subject.
+func (s *Spool) Log(max int) ([]string, error) {
+	iter, err := s.Repo.Log(&git.LogOptions{})
+	if err != nil {
+		s.log.Errorw("failed to read log", "error", err)
+		return nil, err
+	}
+	defer iter.Close()
+	lines := []string{}
+	for len(lines) < max {
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", c.Hash.String()[:7], c.Author.When.UTC().Format(time.RFC3339), c.Author.Name, subject))
+	}
+	return lines, nil
+}
+
 func (s *Spool) AddRemote(name, remote string) error {
 	if name == "" {
 		name = "origin"
@@ -201,6 +460,39 @@ func (s *Spool) AddRemote(name, remote string) error {
 	return err
 }
 
+// SetRemote points remote at url, creating it if needed.
+func (s *Spool) SetRemote(name, remote string) error {
+	if _, err := s.Repo.Remote(name); err == nil {
+		if err := s.Repo.DeleteRemote(name); err != nil {
+			return err
+		}
+	}
+	return s.AddRemote(name, remote)
+}
+
+// PushAllBasic pushes every branch and tag to remote with basic auth, an up
+// to date remote is not an error.
+func (s *Spool) PushAllBasic(remote, user, pass string) error {
+	s.log.Infow("pushing all branches and tags", "remote", remote)
+	err := s.Repo.PushContext(s.ctx, &git.PushOptions{
+		RemoteName: remote,
+		RefSpecs: []config.RefSpec{
+			"refs/heads/*:refs/heads/*",
+			"refs/tags/*:refs/tags/*",
+		},
+		InsecureSkipTLS: true,
+		Auth: &githttp.BasicAuth{
+			Username: user,
+			Password: pass,
+		},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		s.log.Errorw("failed to push", "error", err, "remote", remote)
+		return err
+	}
+	return nil
+}
+
 func (s *Spool) PushBasic(remote, user, pass string) error {
 	remoteObj, err := s.Repo.Remote(remote)
 	if err != nil {
@@ -223,6 +515,24 @@ func (s *Spool) PushBasic(remote, user, pass string) error {
 	return err
 }
 
+// FetchBasic fetches remote with basic auth, an up to date remote is not an
+// error.
+func (s *Spool) FetchBasic(remote, user, pass string) error {
+	err := s.Repo.FetchContext(s.ctx, &git.FetchOptions{
+		RemoteName:      remote,
+		InsecureSkipTLS: true,
+		Auth: &githttp.BasicAuth{
+			Username: user,
+			Password: pass,
+		},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		s.log.Errorw("failed to fetch", "error", err, "remote", remote)
+		return err
+	}
+	return nil
+}
+
 func (s *Spool) AddExisting(path string) error {
 	w, err := s.Repo.Worktree()
 	if err != nil {
@@ -237,6 +547,33 @@ func (s *Spool) AddExisting(path string) error {
 	return nil
 }
 
+// WriteComponentConfig commits params as the pivot.json of the component at
+// path, leaving an existing one untouched so local overrides win.
+func (s *Spool) WriteComponentConfig(path string, params map[string]interface{}) error {
+	f := filepath.Join(s.Path, filepath.Clean(path), ComponentConfig)
+	if !strings.HasPrefix(f, s.Path) {
+		return fmt.Errorf("invalid file path %s", f)
+	}
+	if _, err := os.Stat(f); err == nil {
+		s.log.Infow("keeping existing component config", "path", path)
+		return nil
+	}
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	// components such as init are only written to the repo by pivot run
+	if err := os.MkdirAll(filepath.Dir(f), 0750); err != nil {
+		s.log.Errorw("failed to create component directory", "error", err, "path", path)
+		return err
+	}
+	if err := os.WriteFile(f, append(data, '\n'), 0600); err != nil {
+		s.log.Errorw("failed to write component config", "error", err, "path", path)
+		return err
+	}
+	return s.AddExisting(filepath.Join(path, ComponentConfig))
+}
+
 func (s *Spool) concatFiles(files []string, filePath, separator, msg string) error {
 	w, err := s.Repo.Worktree()
 	if err != nil {
@@ -462,6 +799,9 @@ func (s *Spool) createKustomizationWithNamespace(path, namespace, msg string) er
 		if file.Name() == "namespace.yaml" {
 			continue
 		}
+		if file.Name() == ComponentConfig {
+			continue
+		}
 		str := "- " + file.Name() + "\n"
 		if _, err = fhk.Write([]byte(str)); err != nil {
 			return err
@@ -476,6 +816,107 @@ func (s *Spool) createKustomizationWithNamespace(path, namespace, msg string) er
 	return nil
 }
 
+// validateWorkflow builds every kustomization of the repo on pull requests.
+const validateWorkflow = `name: validate
+on:
+  pull_request:
+jobs:
+  kustomize:
+    runs-on: ubuntu-latest
+    steps:
+    - uses: actions/checkout@v4
+    - name: Install kustomize
+      run: curl -sL https://github.com/kubernetes-sigs/kustomize/releases/download/kustomize%2Fv5.4.3/kustomize_v5.4.3_linux_amd64.tar.gz | tar xz -C /usr/local/bin
+    - name: Build every kustomization
+      run: |
+        status=0
+        for k in $(find . -name kustomization.yaml -not -path './.git/*'); do
+          dir=$(dirname "$k")
+          echo "::group::$dir"
+          kustomize build "$dir" > /dev/null || status=1
+          echo "::endgroup::"
+        done
+        exit $status
+`
+
+// AddValidateWorkflow commits a Gitea Actions workflow validating every
+// kustomization of the repo on pull requests.
+func (s *Spool) AddValidateWorkflow() error {
+	dir := filepath.Join(s.Path, ".gitea", "workflows")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "validate.yaml"), []byte(validateWorkflow), 0600); err != nil {
+		s.log.Errorw("failed to write workflow", "error", err)
+		return err
+	}
+	return s.AddExisting(".gitea/workflows/validate.yaml")
+}
+
+// addResources patches the resources of the Deployments and StatefulSets of
+// the component at path, nothing when resources is empty.
+func (s *Spool) addResources(path, resources string) error {
+	if resources == "" {
+		return nil
+	}
+	return s.addPatch(path, "resources.yaml", resources, "Deployment|StatefulSet", "setting "+path+" resources")
+}
+
+// addPatch writes a patch to the component at path and adds it to the
+// patches of its kustomization, which must already exist. A patch with a
+// target kind is applied to every object of that kind, a Kind/name target
+// to that object only. Kinds are regular expressions, as in kustomize.
+func (s *Spool) addPatch(path, name, patch, target, msg string) error {
+	w, err := s.Repo.Worktree()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(s.Path, filepath.Clean(path))
+	if !strings.HasPrefix(dir, s.Path) {
+		return fmt.Errorf("invalid file path %s", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(patch), 0600); err != nil {
+		s.log.Errorw("failed to write patch", "error", err, "patch", name)
+		return err
+	}
+	kustomization, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		return err
+	}
+	// patches is always the last field of the kustomization
+	entry := "- path: " + name + "\n"
+	if target != "" {
+		kind, name, named := strings.Cut(target, "/")
+		entry += "  target:\n    kind: " + kind + "\n"
+		if named {
+			entry += "    name: " + name + "\n"
+		}
+	}
+	if !strings.Contains(string(kustomization), "\npatches:\n") {
+		entry = "patches:\n" + entry
+	}
+	fhk, err := os.OpenFile(filepath.Join(dir, "kustomization.yaml"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := fhk.Close()
+		if err != nil {
+			s.log.Errorw("error closing file", "error", err)
+		}
+	}()
+	if _, err = fhk.Write([]byte(entry)); err != nil {
+		return err
+	}
+	if _, err = w.Add(path + "/" + name); err != nil {
+		return err
+	}
+	if _, err = w.Add(path + "/kustomization.yaml"); err != nil {
+		return err
+	}
+	return s.commit(msg)
+}
+
 func (s *Spool) createKustomization(path, msg string) error {
 	return s.createKustomizationWithNamespace(path, path, msg)
 }
@@ -0,0 +1,280 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"go.uber.org/zap"
+)
+
+// newTestRepo returns an empty repository in a temporary directory.
+func newTestRepo(t *testing.T) *Spool {
+	t.Helper()
+	path := t.TempDir()
+	if _, err := git.PlainInit(path, false); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenRepo(context.Background(), zap.NewNop().Sugar(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// gitServer serves bare repositories created under its root over smart
+// HTTP with git http-backend, accepting pushes from user with pass only.
+func gitServer(t *testing.T, user, pass string) (string, string) {
+	t.Helper()
+	out, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return root, srv.URL
+}
+
+// bareRepo creates a bare repository name under root that accepts pushes.
+func bareRepo(t *testing.T, root, name string) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if out, err := exec.Command("git", "init", "--bare", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed %v %s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", dir, "config", "http.receivepack", "true").CombinedOutput(); err != nil {
+		t.Fatalf("git config failed %v %s", err, out)
+	}
+}
+
+// commitFile commits a file named name to s.
+func commitFile(t *testing.T, s *Spool, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(s.Path, name), []byte(name+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddExisting(name); err != nil {
+		t.Fatalf("AddExisting failed %v", err)
+	}
+}
+
+func TestFetchBasic(t *testing.T) {
+	root, url := gitServer(t, "pivot", "secret")
+	bareRepo(t, root, "infra.git")
+	s := newTestRepo(t)
+	commitFile(t, s, "README.md")
+	if err := s.AddRemote("local", url+"/infra.git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PushBasic("local", "pivot", "secret"); err != nil {
+		t.Fatalf("PushBasic failed %v", err)
+	}
+	if err := s.FetchBasic("local", "pivot", "secret"); err != nil {
+		t.Errorf("Expected an up to date fetch to succeed, got %v", err)
+	}
+	if err := s.FetchBasic("local", "pivot", "wrong"); err == nil {
+		t.Errorf("Expected a fetch with the wrong password to fail")
+	}
+}
+
+func TestWriteComponentConfigCreatesDirectory(t *testing.T) {
+	s := newTestRepo(t)
+	if err := s.WriteComponentConfig("init", map[string]interface{}{"wave": 0}); err != nil {
+		t.Fatalf("WriteComponentConfig failed %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Path, "init", ComponentConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]interface{}{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		t.Fatal(err)
+	}
+	if params["wave"] != float64(0) {
+		t.Errorf("Expected the params to be written, got %v", params)
+	}
+	lines, err := s.Log(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("Expected the config to be committed, got %v", lines)
+	}
+}
+
+func TestWriteComponentConfigKeepsExisting(t *testing.T) {
+	s := newTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(s.Path, "gitea"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(s.Path, "gitea", ComponentConfig)
+	if err := os.WriteFile(f, []byte("{\"wave\": 5}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteComponentConfig("gitea", map[string]interface{}{"wave": 1}); err != nil {
+		t.Fatalf("WriteComponentConfig failed %v", err)
+	}
+	data, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\"wave\": 5}\n" {
+		t.Errorf("Expected the local override to be kept, got %s", data)
+	}
+}
+
+func TestWriteComponentConfigOutsideRepo(t *testing.T) {
+	s := newTestRepo(t)
+	if err := s.WriteComponentConfig("../escape", map[string]interface{}{}); err == nil {
+		t.Error("Expected a path outside the repository to be rejected")
+	}
+}
+
+func TestAddPatchNamedTarget(t *testing.T) {
+	s := newTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(s.Path, "cert-manager"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Path, "cert-manager", "kustomization.yaml"), []byte("namespace: cert-manager\nresources:\n- cert-manager.yaml\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addPatch("cert-manager", "gateway-api.yaml", certManagerGatewayAPI, "Deployment/cert-manager", "patching"); err != nil {
+		t.Fatalf("addPatch failed %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Path, "cert-manager", "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "patches:\n- path: gateway-api.yaml\n  target:\n    kind: Deployment\n    name: cert-manager\n"
+	if !strings.HasSuffix(string(data), expected) {
+		t.Errorf("Expected the patch to target the cert-manager Deployment only, got\n%s", data)
+	}
+}
+
+func TestAddResourcesStatefulSets(t *testing.T) {
+	s := newTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(s.Path, "argocd"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Path, "argocd", "kustomization.yaml"), []byte("namespace: argocd\nresources:\n- argocd.yaml\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addResources("argocd", "- op: add\n"); err != nil {
+		t.Fatalf("addResources failed %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Path, "argocd", "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Argo CD application controller is a StatefulSet
+	if !strings.HasSuffix(string(data), "    kind: Deployment|StatefulSet\n") {
+		t.Errorf("Expected the resources to target Deployments and StatefulSets, got\n%s", data)
+	}
+}
+
+func TestPushAllBasicAndCloneRepo(t *testing.T) {
+	root, url := gitServer(t, "pivot", "secret")
+	bareRepo(t, root, "infra.git")
+	s := newTestRepo(t)
+	commitFile(t, s, "README.md")
+	head, err := s.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second branch and a tag are pushed along with HEAD
+	if out, err := exec.Command("git", "-C", s.Path, "branch", "feature").CombinedOutput(); err != nil {
+		t.Fatalf("git branch failed %v %s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", s.Path, "tag", "v1").CombinedOutput(); err != nil {
+		t.Fatalf("git tag failed %v %s", err, out)
+	}
+	if err := s.SetRemote("local", url+"/missing.git"); err != nil {
+		t.Fatalf("SetRemote failed %v", err)
+	}
+	if err := s.SetRemote("local", url+"/infra.git"); err != nil {
+		t.Fatalf("SetRemote failed %v", err)
+	}
+	remote, err := s.Repo.Remote("local")
+	if err != nil || remote.Config().URLs[0] != url+"/infra.git" {
+		t.Fatalf("Expected SetRemote to replace the remote, got %v %v", remote, err)
+	}
+	if err := s.PushAllBasic("local", "pivot", "wrong"); err == nil {
+		t.Errorf("Expected a push with the wrong password to fail")
+	}
+	if err := s.PushAllBasic("local", "pivot", "secret"); err != nil {
+		t.Fatalf("PushAllBasic failed %v", err)
+	}
+	if err := s.PushAllBasic("local", "pivot", "secret"); err != nil {
+		t.Errorf("Expected an up to date remote not to be an error, got %v", err)
+	}
+
+	clone, err := CloneRepo(context.Background(), zap.NewNop().Sugar(), filepath.Join(root, "infra.git"), filepath.Join(t.TempDir(), "infra"))
+	if err != nil {
+		t.Fatalf("CloneRepo failed %v", err)
+	}
+	if cloned, err := clone.Head(); err != nil || cloned != head {
+		t.Errorf("Expected the clone at %s, got %v %v", head, cloned, err)
+	}
+	for _, ref := range []string{"refs/heads/feature", "refs/tags/v1"} {
+		if _, err := clone.Repo.Reference(plumbing.ReferenceName(ref), false); err != nil {
+			t.Errorf("Expected %s in the clone, got %v", ref, err)
+		}
+	}
+	if _, err := clone.Repo.Remote("origin"); err == nil {
+		t.Errorf("Expected the origin remote to be removed")
+	}
+}
+
+// statusTransport answers every request with the status of its host.
+type statusTransport map[string]int
+
+func (s statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: s[req.URL.Host],
+		Status:     http.StatusText(s[req.URL.Host]),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func TestCheckSource(t *testing.T) {
+	transport := http.DefaultClient.Transport
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+	http.DefaultClient.Transport = statusTransport{
+		githubAPI:            http.StatusForbidden,
+		"raw.example.com":    http.StatusForbidden,
+		"github.example.com": http.StatusOK,
+	}
+
+	if err := CheckSource(context.TODO(), "https://github.example.com/releases"); err != nil {
+		t.Errorf("Expected a reachable source, got %v", err)
+	}
+	if err := CheckSource(context.TODO(), "https://"+githubAPI+"/repos/a/b/releases/latest"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the GitHub API to be rate limited, got %v", err)
+	}
+	if err := CheckSource(context.TODO(), "https://raw.example.com/install.yaml"); err == nil || errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected other hosts refusing access to be unreachable, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Client talks to the Gitea API of the in-cluster instance as a single user.
+type Client struct {
+	URL      string
+	user     string
+	password string
+	http     *http.Client
+	ctx      context.Context
+	log      *zap.SugaredLogger
+}
+
+// Token is a Gitea access token, Sha1 is only returned when it is created.
+type Token struct {
+	ID     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Sha1   string   `json:"sha1,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// APIError is returned for any non-2xx response.
+type APIError struct {
+	Method  string
+	Path    string
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gitea %s %s: %d %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the Gitea API.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+// NewClient returns a client authenticating to the Gitea at baseURL with
+// basic auth. The in-cluster Gitea serves a self-signed certificate, so TLS
+// verification is skipped just like when pushing to it.
+func NewClient(ctx context.Context, log *zap.SugaredLogger, baseURL, user, password string) *Client {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	return &Client{
+		URL:      baseURL,
+		user:     user,
+		password: password,
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec G402
+			},
+		},
+		ctx: ctx,
+		log: log.Named("gitea").With("url", baseURL, "user", user),
+	}
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, c.URL+"/api/v1"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			c.log.Errorw("failed to close response body", "error", err)
+		}
+	}()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		_ = json.Unmarshal(data, &msg)
+		return &APIError{Method: method, Path: path, Status: res.StatusCode, Message: msg.Message}
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// Healthy reports whether the Gitea instance answers its health check.
+func (c *Client) Healthy() bool {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.URL+"/api/healthz", nil)
+	if err != nil {
+		return false
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
+
+// CheckAuth verifies the client's credentials are accepted.
+func (c *Client) CheckAuth() error {
+	return c.do(http.MethodGet, "/user", nil, nil)
+}
+
+// ListTokens returns the access tokens of the authenticated user.
+func (c *Client) ListTokens() ([]Token, error) {
+	tokens := []Token{}
+	if err := c.do(http.MethodGet, "/users/"+url.PathEscape(c.user)+"/tokens", nil, &tokens); err != nil {
+		c.log.Errorw("failed to list tokens", "error", err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CreateToken creates an access token for the authenticated user.
+func (c *Client) CreateToken(name string, scopes []string) (*Token, error) {
+	token := &Token{}
+	if err := c.do(http.MethodPost, "/users/"+url.PathEscape(c.user)+"/tokens", map[string]interface{}{
+		"name":   name,
+		"scopes": scopes,
+	}, token); err != nil {
+		c.log.Errorw("failed to create token", "error", err, "token", name)
+		return nil, err
+	}
+	return token, nil
+}
+
+// DeleteToken deletes an access token of the authenticated user by name.
+func (c *Client) DeleteToken(name string) error {
+	if err := c.do(http.MethodDelete, "/users/"+url.PathEscape(c.user)+"/tokens/"+url.PathEscape(name), nil, nil); err != nil {
+		c.log.Errorw("failed to delete token", "error", err, "token", name)
+		return err
+	}
+	return nil
+}
+
+// RotateToken creates a new token named prefix-<unix time>, hands it to use
+// and only once use succeeded deletes the tokens it supersedes, so a failure
+// leaves the old tokens working.
+func (c *Client) RotateToken(prefix string, scopes []string, use func(*Token) error) (*Token, []string, error) {
+	existing, err := c.ListTokens()
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := c.CreateToken(fmt.Sprintf("%s-%d", prefix, time.Now().Unix()), scopes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := use(token); err != nil {
+		return nil, nil, err
+	}
+	old := []string{}
+	for _, t := range existing {
+		if t.Name == prefix || strings.HasPrefix(t.Name, prefix+"-") {
+			if err := c.DeleteToken(t.Name); err != nil && !IsNotFound(err) {
+				return token, old, err
+			}
+			old = append(old, t.Name)
+		}
+	}
+	return token, old, nil
+}
+
+// Hook is a repository webhook.
+type Hook struct {
+	ID     int64             `json:"id,omitempty"`
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config"`
+	Events []string          `json:"events"`
+	Active bool              `json:"active"`
+}
+
+// ListHooks returns the webhooks of owner/repo.
+func (c *Client) ListHooks(owner, repo string) ([]Hook, error) {
+	hooks := []Hook{}
+	if err := c.do(http.MethodGet, "/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(repo)+"/hooks", nil, &hooks); err != nil {
+		c.log.Errorw("failed to list hooks", "error", err, "repo", owner+"/"+repo)
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// EnsureHook creates hook on owner/repo, or updates the existing webhook
+// with the same url so registering is idempotent.
+func (c *Client) EnsureHook(owner, repo string, hook Hook) (*Hook, error) {
+	hooks, err := c.ListHooks(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/hooks"
+	method := http.MethodPost
+	for _, h := range hooks {
+		if h.Config["url"] == hook.Config["url"] {
+			path = fmt.Sprintf("%s/%d", path, h.ID)
+			method = http.MethodPatch
+			break
+		}
+	}
+	out := &Hook{}
+	if err := c.do(method, path, hook, out); err != nil {
+		c.log.Errorw("failed to register hook", "error", err, "repo", owner+"/"+repo, "url", hook.Config["url"])
+		return nil, err
+	}
+	return out, nil
+}
+
+// RunnerRegistrationToken returns a token registering Actions runners for
+// every repository of org. Older Gitea only serve it with GET.
+func (c *Client) RunnerRegistrationToken(org string) (string, error) {
+	path := "/orgs/" + url.PathEscape(org) + "/actions/runners/registration-token"
+	out := struct {
+		Token string `json:"token"`
+	}{}
+	err := c.do(http.MethodPost, path, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && (apiErr.Status == http.StatusMethodNotAllowed || apiErr.Status == http.StatusNotFound) {
+		err = c.do(http.MethodGet, path, nil, &out)
+	}
+	if err != nil {
+		c.log.Errorw("failed to get runner registration token", "error", err, "org", org)
+		return "", err
+	}
+	return out.Token, nil
+}
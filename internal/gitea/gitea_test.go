@@ -0,0 +1,221 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeHooks serves the hooks API of infra/infra from hooks, recording the
+// method of every write.
+func fakeHooks(t *testing.T, hooks []Hook, writes *[]string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/repos/infra/infra/hooks", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "pivot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(hooks)
+		case http.MethodPost:
+			*writes = append(*writes, r.Method)
+			hook := Hook{}
+			_ = json.NewDecoder(r.Body).Decode(&hook)
+			hook.ID = int64(len(hooks) + 1)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(hook)
+		}
+	})
+	mux.HandleFunc("/api/v1/repos/infra/infra/hooks/1", func(w http.ResponseWriter, r *http.Request) {
+		*writes = append(*writes, r.Method)
+		hook := Hook{}
+		_ = json.NewDecoder(r.Body).Decode(&hook)
+		hook.ID = 1
+		_ = json.NewEncoder(w).Encode(hook)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestClient(url, pass string) *Client {
+	return NewClient(context.Background(), zap.NewNop().Sugar(), url, "pivot", pass)
+}
+
+func TestListHooks(t *testing.T) {
+	writes := []string{}
+	srv := fakeHooks(t, []Hook{{ID: 1, Type: "gogs", Config: map[string]string{"url": "https://argocd.example.com/api/webhook"}}}, &writes)
+	hooks, err := newTestClient(srv.URL, "secret").ListHooks("infra", "infra")
+	if err != nil {
+		t.Fatalf("ListHooks failed %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].Config["url"] != "https://argocd.example.com/api/webhook" {
+		t.Errorf("Expected the registered hook, got %v", hooks)
+	}
+	_, err = newTestClient(srv.URL, "wrong").ListHooks("infra", "infra")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("Expected an API error for bad credentials, got %v", err)
+	}
+}
+
+func TestEnsureHook(t *testing.T) {
+	hook := Hook{Type: "gogs", Config: map[string]string{"url": "https://argocd.example.com/api/webhook"}, Events: []string{"push"}, Active: true}
+
+	writes := []string{}
+	srv := fakeHooks(t, []Hook{}, &writes)
+	created, err := newTestClient(srv.URL, "secret").EnsureHook("infra", "infra", hook)
+	if err != nil {
+		t.Fatalf("EnsureHook failed %v", err)
+	}
+	if created.ID != 1 || len(writes) != 1 || writes[0] != http.MethodPost {
+		t.Errorf("Expected the hook to be created, got %v after %v", created, writes)
+	}
+
+	writes = []string{}
+	srv = fakeHooks(t, []Hook{{ID: 1, Type: "gogs", Config: hook.Config}}, &writes)
+	if _, err := newTestClient(srv.URL, "secret").EnsureHook("infra", "infra", hook); err != nil {
+		t.Fatalf("EnsureHook failed %v", err)
+	}
+	if len(writes) != 1 || writes[0] != http.MethodPatch {
+		t.Errorf("Expected the existing hook to be updated, got %v", writes)
+	}
+}
+
+func TestRunnerRegistrationToken(t *testing.T) {
+	for _, post := range []bool{true, false} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/orgs/infra/actions/runners/registration-token" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// older Gitea only serve the token with GET
+			if (r.Method == http.MethodPost) != post {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "registration"})
+		}))
+		token, err := newTestClient(srv.URL, "secret").RunnerRegistrationToken("infra")
+		srv.Close()
+		if err != nil || token != "registration" {
+			t.Errorf("Expected the registration token with POST %v, got %v %v", post, token, err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	if _, err := newTestClient(srv.URL, "secret").RunnerRegistrationToken("infra"); err == nil {
+		t.Errorf("Expected a forbidden token request to fail")
+	}
+}
+
+func TestCheckAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); r.URL.Path != "/api/v1/user" || !ok || user != "pivot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"user does not exist"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"login":"pivot"}`))
+	}))
+	defer srv.Close()
+	if err := newTestClient(srv.URL, "secret").CheckAuth(); err != nil {
+		t.Errorf("Expected the credentials to be accepted, got %v", err)
+	}
+	err := newTestClient(srv.URL, "old").CheckAuth()
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Status != http.StatusUnauthorized || apiErr.Message != "user does not exist" {
+		t.Errorf("Expected an unauthorized API error, got %v", err)
+	}
+}
+
+// fakeTokens serves the tokens API of the pivot user from tokens, recording
+// every write as "METHOD name". Creating a token fails with createStatus
+// unless it is 0.
+func fakeTokens(t *testing.T, tokens []Token, createStatus int, writes *[]string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/pivot/tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(tokens)
+		case http.MethodPost:
+			token := Token{}
+			_ = json.NewDecoder(r.Body).Decode(&token)
+			*writes = append(*writes, r.Method+" "+token.Name)
+			if createStatus != 0 {
+				w.WriteHeader(createStatus)
+				return
+			}
+			token.ID = int64(len(tokens) + 1)
+			token.Sha1 = "new-sha"
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(token)
+		}
+	})
+	mux.HandleFunc("/api/v1/users/pivot/tokens/", func(w http.ResponseWriter, r *http.Request) {
+		*writes = append(*writes, r.Method+" "+r.URL.Path[len("/api/v1/users/pivot/tokens/"):])
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRotateToken(t *testing.T) {
+	writes := []string{}
+	srv := fakeTokens(t, []Token{{ID: 1, Name: "pivot"}, {ID: 2, Name: "pivot-1700000000"}, {ID: 3, Name: "pivot-ci"}, {ID: 4, Name: "other"}}, 0, &writes)
+	used := ""
+	token, old, err := newTestClient(srv.URL, "secret").RotateToken("pivot", []string{"read:repository"}, func(token *Token) error {
+		used = token.Sha1
+		writes = append(writes, "use "+token.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RotateToken failed %v", err)
+	}
+	if used != "new-sha" || token.Sha1 != "new-sha" || !strings.HasPrefix(token.Name, "pivot-") {
+		t.Errorf("Expected the new token to be handed over, got %v %v", used, token)
+	}
+	want := []string{"POST " + token.Name, "use " + token.Name, "DELETE pivot", "DELETE pivot-1700000000", "DELETE pivot-ci"}
+	if strings.Join(writes, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected the old tokens to be deleted after the new one is in use, got %v", writes)
+	}
+	if strings.Join(old, ",") != "pivot,pivot-1700000000,pivot-ci" {
+		t.Errorf("Expected the superseded tokens, got %v", old)
+	}
+}
+
+func TestRotateTokenKeepsOldTokens(t *testing.T) {
+	writes := []string{}
+	srv := fakeTokens(t, []Token{{ID: 1, Name: "pivot-1700000000"}}, http.StatusInternalServerError, &writes)
+	if _, _, err := newTestClient(srv.URL, "secret").RotateToken("pivot", nil, func(*Token) error {
+		t.Errorf("Expected a failed create to hand over no token")
+		return nil
+	}); err == nil {
+		t.Errorf("Expected a failed create to fail the rotation")
+	}
+	if len(writes) != 1 || !strings.HasPrefix(writes[0], "POST ") {
+		t.Errorf("Expected the old tokens to be left intact, got %v", writes)
+	}
+
+	writes = []string{}
+	srv = fakeTokens(t, []Token{{ID: 1, Name: "pivot-1700000000"}}, 0, &writes)
+	if _, _, err := newTestClient(srv.URL, "secret").RotateToken("pivot", nil, func(*Token) error {
+		return errors.New("secret not updated")
+	}); err == nil {
+		t.Errorf("Expected a failure to use the token to fail the rotation")
+	}
+	if len(writes) != 1 || !strings.HasPrefix(writes[0], "POST ") {
+		t.Errorf("Expected the old tokens to be left intact, got %v", writes)
+	}
+}
@@ -0,0 +1,194 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ACTIONS is the component running the Gitea Actions runner
+	ACTIONS = "actions"
+
+	actRunner      = "act-runner"
+	actRunnerImage = "gitea/act_runner:latest-dind-rootless"
+	// actRunnerConfig trusts the self-signed certificate of the in-cluster
+	// Gitea and runs jobs in containers of the runner's rootless docker
+	actRunnerConfig = `runner:
+  insecure: true
+  labels:
+  - ubuntu-latest:docker://node:20-bookworm
+`
+	// actRunnerCA is where the pivot CA is mounted, in the runner and in
+	// the job containers
+	actRunnerCA = "/etc/pivot"
+)
+
+var deploymentGVR = schema.GroupVersionResource{
+	Group:    "apps",
+	Version:  "v1",
+	Resource: "deployments",
+}
+
+// ActionsComponent is the component deploying the Actions runner.
+func ActionsComponent() Component {
+	return Component{
+		Path:      ACTIONS,
+		Namespace: DEFAULT,
+		Prune:     true,
+		SelfHeal:  true,
+		Retry:     defaultRetry,
+		Wave:      4,
+	}
+}
+
+// runnerConfig is the act runner config. With a CA, the jobs' git and node
+// trust it for Gitea at domain, so actions/checkout can clone over HTTPS.
+func runnerConfig(domain string, ca []byte) string {
+	if len(ca) == 0 {
+		return actRunnerConfig
+	}
+	cert := actRunnerCA + "/ca.crt"
+	return actRunnerConfig + `  envs:
+    NODE_EXTRA_CA_CERTS: ` + cert + `
+    GIT_CONFIG_COUNT: "1"
+    GIT_CONFIG_KEY_0: http.https://` + domain + `/.sslCAInfo
+    GIT_CONFIG_VALUE_0: ` + cert + `
+container:
+  options: -v ` + actRunnerCA + `:` + actRunnerCA + `:ro
+  valid_volumes:
+  - ` + actRunnerCA + `
+`
+}
+
+// CreateActionsRunner deploys an act runner registering with token, whose
+// jobs trust ca, the PEM CA of Gitea at domain, when it is set. The token is
+// kept in a Secret in the cluster, only the runner's ConfigMaps and
+// Deployment are committed.
+func (k *K8s) CreateActionsRunner(token, domain string, ca []byte) error {
+	k.list[ACTIONS] = []*unstructured.Unstructured{}
+	secret := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      actRunner + "-token",
+				NAMESPACE: DEFAULT,
+			},
+			"type": "Opaque",
+			"data": map[string]interface{}{
+				"token": base64.StdEncoding.EncodeToString([]byte(token)),
+			},
+		},
+	}
+	if err := k.create(ACTIONS, secretGVR, secret); err != nil {
+		return err
+	}
+
+	config := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "ConfigMap",
+			METADATA: map[string]interface{}{
+				NAME:      actRunner,
+				NAMESPACE: DEFAULT,
+			},
+			"data": map[string]interface{}{
+				"config.yaml": runnerConfig(domain, ca),
+			},
+		},
+	}
+	k.list[ACTIONS] = append(k.list[ACTIONS], config)
+	if err := k.create(ACTIONS, configMapGVR, config); err != nil {
+		return err
+	}
+	mounts := []interface{}{
+		map[string]interface{}{NAME: "config", "mountPath": "/config"},
+		map[string]interface{}{NAME: "data", "mountPath": "/data"},
+	}
+	volumes := []interface{}{
+		map[string]interface{}{
+			NAME:        "config",
+			"configMap": map[string]interface{}{NAME: actRunner},
+		},
+		map[string]interface{}{
+			NAME:       "data",
+			"emptyDir": map[string]interface{}{},
+		},
+	}
+	if len(ca) > 0 {
+		cm := caConfigMap(DEFAULT, ca)
+		cm.SetName(actRunner + "-ca")
+		k.list[ACTIONS] = append(k.list[ACTIONS], cm)
+		if err := k.create(ACTIONS, configMapGVR, cm); err != nil {
+			return err
+		}
+		mounts = append(mounts, map[string]interface{}{NAME: "ca", "mountPath": actRunnerCA, "readOnly": true})
+		volumes = append(volumes, map[string]interface{}{
+			NAME:        "ca",
+			"configMap": map[string]interface{}{NAME: actRunner + "-ca"},
+		})
+	}
+
+	labels := map[string]interface{}{"app.kubernetes.io/name": actRunner}
+	deployment := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "apps/v1",
+			KIND:       "Deployment",
+			METADATA: map[string]interface{}{
+				NAME:      actRunner,
+				NAMESPACE: DEFAULT,
+			},
+			SPEC: map[string]interface{}{
+				"replicas": int64(1),
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					METADATA: map[string]interface{}{"labels": labels},
+					SPEC: map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								NAME:    "runner",
+								"image": actRunnerImage,
+								"env": []interface{}{
+									map[string]interface{}{NAME: "GITEA_INSTANCE_URL", "value": GiteaURL},
+									map[string]interface{}{NAME: "GITEA_RUNNER_NAME", "value": actRunner},
+									map[string]interface{}{NAME: "CONFIG_FILE", "value": "/config/config.yaml"},
+									map[string]interface{}{NAME: "DOCKER_HOST", "value": "unix:///var/run/user/1000/docker.sock"},
+									map[string]interface{}{
+										NAME: "GITEA_RUNNER_REGISTRATION_TOKEN",
+										"valueFrom": map[string]interface{}{
+											"secretKeyRef": map[string]interface{}{
+												NAME:  actRunner + "-token",
+												"key": "token",
+											},
+										},
+									},
+								},
+								// rootless docker still needs a privileged container
+								"securityContext": map[string]interface{}{"privileged": true},
+								"volumeMounts":    mounts,
+							},
+						},
+						"volumes": volumes,
+					},
+				},
+			},
+		},
+	}
+	k.list[ACTIONS] = append(k.list[ACTIONS], deployment)
+	if err := k.create(ACTIONS, deploymentGVR, deployment); err != nil {
+		return err
+	}
+	return k.SaveInventory(ACTIONS)
+}
+
+func (k *K8s) WriteActionsToFile(path string) error {
+	if len(k.list[ACTIONS]) == 0 {
+		k.log.Error("no objects to write, you may need to run CreateActionsRunner first")
+		return errors.New("no objects to write, you may need to run CreateActionsRunner first")
+	}
+	return k.writeToFile(ACTIONS, path)
+}
@@ -0,0 +1,60 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCreateActionsRunner(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.CreateActionsRunner("registration", "git.example.com", nil); err != nil {
+		t.Fatalf("CreateActionsRunner failed %v", err)
+	}
+	for _, obj := range k.list[ACTIONS] {
+		if obj.GetKind() == "Secret" {
+			t.Errorf("Expected the registration token to never be written to the repo")
+		}
+	}
+	if token, err := k.GetSecretValue(DEFAULT, actRunner+"-token", "token"); err != nil || token != "registration" {
+		t.Errorf("Expected the registration token in the cluster, got %v %v", token, err)
+	}
+	inv, err := k.GetInventory(ACTIONS)
+	if err != nil || inv == nil || len(inv.Entries) != 3 {
+		t.Errorf("Expected the runner in the actions inventory, got %v %v", inv, err)
+	}
+}
+
+func TestCreateActionsRunnerCA(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.CreateActionsRunner("registration", "git.example.com", []byte("ca")); err != nil {
+		t.Fatalf("CreateActionsRunner failed %v", err)
+	}
+	var config string
+	mounted := false
+	for _, obj := range k.list[ACTIONS] {
+		switch {
+		case obj.GetName() == actRunner && obj.GetKind() == "ConfigMap":
+			config, _, _ = unstructured.NestedString(obj.Object, "data", "config.yaml")
+		case obj.GetName() == actRunner+"-ca":
+			if ca, _, _ := unstructured.NestedString(obj.Object, "data", "ca.crt"); ca != "ca" {
+				t.Errorf("Expected the CA in the runner's ConfigMap, got %v", obj.Object)
+			}
+		case obj.GetKind() == "Deployment":
+			containers, _, _ := unstructured.NestedSlice(obj.Object, SPEC, "template", SPEC, "containers")
+			mounts, _, _ := unstructured.NestedSlice(containers[0].(map[string]interface{}), "volumeMounts")
+			for _, m := range mounts {
+				mounted = mounted || m.(map[string]interface{})["mountPath"] == actRunnerCA
+			}
+		}
+	}
+	if !mounted {
+		t.Errorf("Expected the CA to be mounted into the runner")
+	}
+	for _, want := range []string{"GIT_CONFIG_KEY_0: http.https://git.example.com/.sslCAInfo", "options: -v /etc/pivot:/etc/pivot:ro"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Expected the jobs to trust the CA with %q, got\n%s", want, config)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"hyperspike.io/pivot/internal/git"
+)
+
+const (
+	// PLATFORM is the Argo CD AppProject the platform components belong to
+	PLATFORM = "platform"
+	// InClusterServer is the Argo CD destination of the local cluster
+	InClusterServer = "https://kubernetes.default.svc"
+	// WaveLabel carries the rollout wave of a generated Application
+	WaveLabel = "pivot.hyperspike.io/wave"
+)
+
+// Generator selects how the init ApplicationSet discovers components.
+type Generator string
+
+const (
+	// ListGenerator deploys exactly the components pivot knows about
+	ListGenerator Generator = "list"
+	// GitGenerator deploys every top level directory of the infra repo
+	GitGenerator Generator = "git"
+)
+
+// SetDestinations adds namespaces the platform AppProject allows
+// Applications to deploy to besides those of the components, "*" allows any
+// namespace.
+func (k *K8s) SetDestinations(namespaces []string) error {
+	for _, ns := range namespaces {
+		if ns == "*" && k.namespaced {
+			return fmt.Errorf("namespaced, Argo CD cannot deploy to any namespace")
+		}
+	}
+	k.destinations = namespaces
+	return nil
+}
+
+// SetRollingSync rolls the Applications of the init ApplicationSet out wave
+// by wave. The ApplicationSet controller then triggers their syncs, so they
+// have no automated sync policy and changes pushed later are only synced by
+// the controller, not self healed.
+func (k *K8s) SetRollingSync() {
+	k.rollingSync = true
+}
+
+// SetGenerator selects the generator of the init ApplicationSet, exclude are
+// path patterns of directories the git generator skips.
+func (k *K8s) SetGenerator(g Generator, exclude []string) error {
+	switch g {
+	case ListGenerator, GitGenerator:
+	default:
+		return fmt.Errorf("unknown generator %q, expected %s or %s", g, ListGenerator, GitGenerator)
+	}
+	k.generator = g
+	k.exclude = exclude
+	return nil
+}
+
+var appProjectGVR = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "appprojects",
+}
+
+// clusterKind is a group/kind pair of an AppProject resource whitelist.
+type clusterKind struct {
+	group string
+	kind  string
+}
+
+// appProject builds the platform AppProject. Sources are restricted to the
+// in-cluster infra repo, destinations to the namespaces of the components
+// and the cluster-scoped whitelist to the kinds pivot itself applied.
+func (k *K8s) appProject() *unstructured.Unstructured {
+	namespaces := map[string]bool{}
+	for _, c := range k.components {
+		namespaces[c.Namespace] = true
+	}
+	kinds := map[clusterKind]bool{}
+	// namespaced, Argo CD cannot create namespaces either
+	if !k.namespaced {
+		kinds[clusterKind{group: "", kind: "Namespace"}] = true
+	}
+	for _, entries := range k.applied {
+		for _, e := range entries {
+			if e.Namespace == "" {
+				kinds[clusterKind{group: e.Group, kind: e.Kind}] = true
+			} else {
+				namespaces[e.Namespace] = true
+			}
+		}
+	}
+
+	for _, ns := range append(append([]string{}, k.destinations...), k.namespaces...) {
+		namespaces[ns] = true
+	}
+
+	names := make([]string, 0, len(namespaces))
+	for ns := range namespaces {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	destinations := make([]interface{}, 0, len(names))
+	for _, ns := range names {
+		destinations = append(destinations, map[string]interface{}{
+			NAMESPACE: ns,
+			"server":  InClusterServer,
+		})
+	}
+
+	sorted := make([]clusterKind, 0, len(kinds))
+	for ck := range kinds {
+		sorted = append(sorted, ck)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].group != sorted[j].group {
+			return sorted[i].group < sorted[j].group
+		}
+		return sorted[i].kind < sorted[j].kind
+	})
+	whitelist := make([]interface{}, 0, len(sorted))
+	for _, ck := range sorted {
+		whitelist = append(whitelist, map[string]interface{}{
+			"group": ck.group,
+			KIND:    ck.kind,
+		})
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "argoproj.io/v1alpha1",
+			KIND:       "AppProject",
+			METADATA: map[string]interface{}{
+				NAME:      PLATFORM,
+				NAMESPACE: ARGOCD,
+				// keep the project until every Application using it is gone
+				"finalizers": []interface{}{"resources-finalizer.argocd.argoproj.io"},
+			},
+			SPEC: map[string]interface{}{
+				"description":              "Platform components bootstrapped by pivot",
+				"sourceRepos":              []interface{}{InfraRepoURL},
+				"destinations":             destinations,
+				"clusterResourceWhitelist": whitelist,
+				"namespaceResourceWhitelist": []interface{}{
+					map[string]interface{}{"group": "*", KIND: "*"},
+				},
+			},
+		},
+	}
+}
+
+// generators builds the generators of the init ApplicationSet. Both produce
+// a path parameter shaped like the one of the git directory generator, so
+// the template is the same either way.
+func (k *K8s) generators() ([]interface{}, error) {
+	if k.generator != GitGenerator {
+		elements := make([]interface{}, 0, len(k.components))
+		for _, c := range k.components {
+			params, err := c.Params()
+			if err != nil {
+				return nil, err
+			}
+			params[PATH] = map[string]interface{}{
+				PATH:       c.Path,
+				"basename": c.Path,
+			}
+			elements = append(elements, params)
+		}
+		return []interface{}{
+			map[string]interface{}{
+				"list": map[string]interface{}{
+					"elements": elements,
+				},
+			},
+		}, nil
+	}
+
+	directories := []interface{}{
+		map[string]interface{}{PATH: "*"},
+		// dot directories hold tooling, not manifests
+		map[string]interface{}{PATH: ".*", "exclude": true},
+	}
+	for _, pattern := range k.exclude {
+		directories = append(directories, map[string]interface{}{PATH: pattern, "exclude": true})
+	}
+	// overrides from <dir>/pivot.json are merged onto the directory by name
+	return []interface{}{
+		map[string]interface{}{
+			"merge": map[string]interface{}{
+				"mergeKeys": []interface{}{"path.basename"},
+				"generators": []interface{}{
+					map[string]interface{}{
+						"git": map[string]interface{}{
+							"repoURL":     InfraRepoURL,
+							"revision":    "HEAD",
+							"directories": directories,
+						},
+					},
+					map[string]interface{}{
+						"git": map[string]interface{}{
+							"repoURL":  InfraRepoURL,
+							"revision": "HEAD",
+							"files": []interface{}{
+								map[string]interface{}{PATH: "*/" + git.ComponentConfig},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// waves returns the waves of the components in ascending order, with the git
+// generator followed by the wave of directories that do not set one.
+func (k *K8s) waves() []int {
+	seen := map[int]bool{}
+	waves := []int{}
+	for _, c := range k.components {
+		if !seen[c.Wave] {
+			seen[c.Wave] = true
+			waves = append(waves, c.Wave)
+		}
+	}
+	sort.Ints(waves)
+	if len(waves) == 0 {
+		waves = append(waves, 0)
+	}
+	if k.generator == GitGenerator {
+		waves = append(waves, waves[len(waves)-1]+1)
+	}
+	return waves
+}
+
+// rollingSync builds a RollingSync strategy with a step per wave.
+func rollingSync(waves []int) map[string]interface{} {
+	steps := make([]interface{}, 0, len(waves))
+	for _, w := range waves {
+		steps = append(steps, map[string]interface{}{
+			"matchExpressions": []interface{}{
+				map[string]interface{}{
+					"key":      WaveLabel,
+					"operator": "In",
+					"values":   []interface{}{strconv.Itoa(w)},
+				},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"type": "RollingSync",
+		"rollingSync": map[string]interface{}{
+			"steps": steps,
+		},
+	}
+}
+
+// automatedPatch sets the automated sync policy of an Application from the
+// prune and selfHeal settings.
+const automatedPatch = `    automated:
+      prune: {{ index . "prune" | default false }}
+      selfHeal: {{ index . "selfHeal" | default false }}
+`
+
+// optionsPatch sets the sync options, retry and ignored differences of an
+// Application.
+const optionsPatch = `    {{- with index . "syncOptions" }}
+    syncOptions: {{ toJson . }}
+    {{- end }}
+    {{- with index . "retry" }}
+    retry: {{ toJson . }}
+    {{- end }}
+  {{- with index . "ignoreDifferences" }}
+  ignoreDifferences: {{ toJson . }}
+  {{- end }}
+`
+
+// templatePatch applies the structured Component settings, which cannot be
+// expressed as strings in the template itself. Under a RollingSync the
+// ApplicationSet controller triggers the syncs, so automated is left out.
+func (k *K8s) templatePatch() string {
+	patch := "spec:\n  syncPolicy:\n"
+	if !k.rollingSync {
+		patch += automatedPatch
+	}
+	return patch + optionsPatch
+}
+
+// applicationSet builds the init ApplicationSet generating an Application
+// per component. Optional parameters are read with index so components
+// without overrides fall back to the defaults.
+func (k *K8s) applicationSet() (*unstructured.Unstructured, error) {
+	generators, err := k.generators()
+	if err != nil {
+		return nil, err
+	}
+	// directories without a wave roll out last
+	waves := k.waves()
+	wave := `{{ if hasKey . "wave" }}{{ .wave }}{{ else }}` + strconv.Itoa(waves[len(waves)-1]) + `{{ end }}`
+	syncPolicy := map[string]interface{}{}
+	if !k.rollingSync {
+		syncPolicy["automated"] = map[string]interface{}{}
+	}
+	set := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "argoproj.io/v1alpha1",
+			KIND:       "ApplicationSet",
+			METADATA: map[string]interface{}{
+				NAME:      INIT,
+				NAMESPACE: ARGOCD,
+			},
+			SPEC: map[string]interface{}{
+				"goTemplate":        true,
+				"goTemplateOptions": []interface{}{"missingkey=error"},
+				"generators":        generators,
+				"templatePatch":     k.templatePatch(),
+				"template": map[string]interface{}{
+					METADATA: map[string]interface{}{
+						NAME: "{{.path.basename}}",
+						"labels": map[string]interface{}{
+							"app.kubernetes.io/managed-by": "argocd.argoproj.io",
+							"app.kubernetes.io/instance":   "{{.path.basename}}",
+							WaveLabel:                      wave,
+						},
+						"annotations": map[string]interface{}{
+							"argocd.argoproj.io/manifest-generate-paths": ".", // this is the path to the kustomization.yaml
+						},
+					},
+					SPEC: map[string]interface{}{
+						"destination": map[string]interface{}{
+							NAMESPACE: `{{ index . "namespace" | default "` + ARGOCD + `" }}`,
+							"server":  InClusterServer,
+						},
+						"project": PLATFORM,
+						"source": map[string]interface{}{
+							PATH:             "{{.path.path}}",
+							"repoURL":        InfraRepoURL,
+							"targetRevision": `{{ index . "targetRevision" | default "HEAD" }}`,
+						},
+						"syncPolicy": syncPolicy,
+					},
+				},
+			},
+		},
+	}
+	if k.rollingSync {
+		if err := unstructured.SetNestedField(set.Object, rollingSync(waves), SPEC, "strategy"); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// WebhookSecret returns the secret Argo CD verifies Gitea push events with,
+// empty when none is set.
+func (k *K8s) WebhookSecret() (string, error) {
+	return k.GetSecretValue(ARGOCD, "argocd-secret", "webhook.gogs.secret")
+}
+
+// SetWebhookSecret stores the secret Argo CD verifies Gitea push events with.
+// Gitea's webhooks are Gogs compatible, so it is the Gogs secret.
+func (k *K8s) SetWebhookSecret(secret string) error {
+	k.log.Infow("Setting webhook secret", NAMESPACE, ARGOCD, NAME, "argocd-secret")
+	return k.SetSecretData(ARGOCD, "argocd-secret", map[string]string{
+		"webhook.gogs.secret": secret,
+	})
+}
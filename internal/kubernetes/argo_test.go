@@ -0,0 +1,231 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"hyperspike.io/pivot/internal/git"
+)
+
+func TestCreateArgoInitProject(t *testing.T) {
+	k, client := newFakeK8s(t)
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName("certificates.cert-manager.io")
+	k.track("cert-manager", schema.GroupVersionResource{
+		Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions",
+	}, crd)
+
+	if err := k.CreateArgoInit("", "pivot", "secret"); err != nil {
+		t.Fatalf("CreateArgoInit failed %v", err)
+	}
+	project, err := client.Resource(appProjectGVR).Namespace(ARGOCD).Get(context.TODO(), PLATFORM, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected platform project to be created %v", err)
+	}
+	repos, _, _ := unstructured.NestedStringSlice(project.Object, SPEC, "sourceRepos")
+	if len(repos) != 1 || repos[0] != InfraRepoURL {
+		t.Errorf("Expected sources to be restricted to %s, got %v", InfraRepoURL, repos)
+	}
+	whitelist, _, _ := unstructured.NestedSlice(project.Object, SPEC, "clusterResourceWhitelist")
+	found := false
+	for _, w := range whitelist {
+		if w.(map[string]interface{})[KIND] == "CustomResourceDefinition" {
+			found = true
+		}
+		if w.(map[string]interface{})[KIND] == "*" {
+			t.Errorf("Expected no wildcard in the cluster whitelist")
+		}
+	}
+	if !found {
+		t.Errorf("Expected CustomResourceDefinition in the cluster whitelist, got %v", whitelist)
+	}
+	namespaces := map[string]bool{}
+	for _, c := range DefaultComponents() {
+		namespaces[c.Namespace] = true
+	}
+	destinations, _, _ := unstructured.NestedSlice(project.Object, SPEC, "destinations")
+	if len(destinations) != len(namespaces) {
+		t.Errorf("Expected a destination per component namespace, got %v", destinations)
+	}
+
+	app, err := client.Resource(applicationGVR).Namespace(ARGOCD).Get(context.TODO(), INIT, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected init application to be created %v", err)
+	}
+	if p, _, _ := unstructured.NestedString(app.Object, SPEC, "project"); p != PLATFORM {
+		t.Errorf("Expected init application in the platform project, got %v", p)
+	}
+	if len(k.list[ARGOCD]) != 3 || k.list[ARGOCD][0].GetKind() != "AppProject" {
+		t.Errorf("Expected the project to be written to the init directory first")
+	}
+}
+
+func TestApplicationSetGenerators(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	list, err := k.applicationSet()
+	if err != nil {
+		t.Fatalf("applicationSet failed %v", err)
+	}
+	elements, _, _ := unstructured.NestedSlice(list.Object, SPEC, "generators")
+	if len(elements) != 1 {
+		t.Fatalf("Expected a single generator, got %v", elements)
+	}
+	items, _, _ := unstructured.NestedSlice(elements[0].(map[string]interface{}), "list", "elements")
+	if len(items) != len(DefaultComponents()) {
+		t.Errorf("Expected an element per component, got %d", len(items))
+	}
+
+	if err := k.SetGenerator("helm", nil); err == nil {
+		t.Errorf("Expected an unknown generator to be rejected")
+	}
+	if err := k.SetGenerator(GitGenerator, []string{"scratch-*"}); err != nil {
+		t.Fatalf("SetGenerator failed %v", err)
+	}
+	set, err := k.applicationSet()
+	if err != nil {
+		t.Fatalf("applicationSet failed %v", err)
+	}
+	generators, _, _ := unstructured.NestedSlice(set.Object, SPEC, "generators")
+	merge, _, _ := unstructured.NestedMap(generators[0].(map[string]interface{}), "merge")
+	keys, _, _ := unstructured.NestedStringSlice(merge, "mergeKeys")
+	if len(keys) != 1 || keys[0] != "path.basename" {
+		t.Errorf("Expected overrides merged by directory, got %v", keys)
+	}
+	children, _, _ := unstructured.NestedSlice(merge, "generators")
+	directories, _, _ := unstructured.NestedSlice(children[0].(map[string]interface{}), "git", "directories")
+	last := directories[len(directories)-1].(map[string]interface{})
+	if last[PATH] != "scratch-*" || last["exclude"] != true {
+		t.Errorf("Expected the exclude pattern to be passed on, got %v", last)
+	}
+	files, _, _ := unstructured.NestedSlice(children[1].(map[string]interface{}), "git", "files")
+	if files[0].(map[string]interface{})[PATH] != "*/"+git.ComponentConfig {
+		t.Errorf("Expected overrides read from %s, got %v", git.ComponentConfig, files)
+	}
+	destinations, _, _ := unstructured.NestedSlice(k.appProject().Object, SPEC, "destinations")
+	for _, d := range destinations {
+		if d.(map[string]interface{})[NAMESPACE] == "*" {
+			t.Errorf("Expected the git generator not to allow any namespace by default, got %v", destinations)
+		}
+	}
+	if err := k.SetDestinations([]string{"*"}); err != nil {
+		t.Fatalf("SetDestinations failed %v", err)
+	}
+	destinations, _, _ = unstructured.NestedSlice(k.appProject().Object, SPEC, "destinations")
+	if destinations[0].(map[string]interface{})[NAMESPACE] != "*" {
+		t.Errorf("Expected an explicit wildcard destination, got %v", destinations)
+	}
+}
+
+func TestApplicationSetComponentParams(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	k.AddComponent(Component{
+		Path:        "monitoring",
+		Namespace:   "monitoring",
+		SyncOptions: []string{"CreateNamespace=true"},
+		Prune:       true,
+	})
+	apps, err := k.applicationSet()
+	if err != nil {
+		t.Fatalf("applicationSet failed %v", err)
+	}
+	generators, _, _ := unstructured.NestedSlice(apps.Object, SPEC, "generators")
+	elements, _, _ := unstructured.NestedSlice(generators[0].(map[string]interface{}), "list", "elements")
+	params := map[string]map[string]interface{}{}
+	for _, e := range elements {
+		name, _, _ := unstructured.NestedString(e.(map[string]interface{}), PATH, "basename")
+		params[name] = e.(map[string]interface{})
+	}
+	argo := params[ARGOCD]
+	if opts, _, _ := unstructured.NestedStringSlice(argo, "syncOptions"); len(opts) != 1 || opts[0] != "ServerSideApply=true" {
+		t.Errorf("Expected argocd to use server side apply, got %v", opts)
+	}
+	if _, ok := params["cert-manager"]["ignoreDifferences"]; !ok {
+		t.Errorf("Expected cert-manager to ignore webhook caBundles")
+	}
+	monitoring := params["monitoring"]
+	if monitoring[NAMESPACE] != "monitoring" || monitoring["prune"] != true {
+		t.Errorf("Expected the added component's settings as parameters, got %v", monitoring)
+	}
+	if _, ok := monitoring["selfHeal"]; ok {
+		t.Errorf("Expected unset settings to be left to the template defaults, got %v", monitoring)
+	}
+	if patch, _, _ := unstructured.NestedString(apps.Object, SPEC, "templatePatch"); patch == "" {
+		t.Errorf("Expected a templatePatch applying the settings")
+	}
+}
+
+func TestApplicationSetAutomatedByDefault(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	apps, err := k.applicationSet()
+	if err != nil {
+		t.Fatalf("applicationSet failed %v", err)
+	}
+	if _, found, _ := unstructured.NestedMap(apps.Object, SPEC, "strategy"); found {
+		t.Errorf("Expected no rollout strategy unless opted in")
+	}
+	if _, found, _ := unstructured.NestedMap(apps.Object, SPEC, "template", SPEC, "syncPolicy", "automated"); !found {
+		t.Errorf("Expected Applications to sync automatically")
+	}
+	if patch, _, _ := unstructured.NestedString(apps.Object, SPEC, "templatePatch"); !strings.Contains(patch, "automated:") {
+		t.Errorf("Expected the templatePatch to set the automated policy, got %s", patch)
+	}
+}
+
+func TestApplicationSetRollingSync(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	k.SetRollingSync()
+	apps, err := k.applicationSet()
+	if err != nil {
+		t.Fatalf("applicationSet failed %v", err)
+	}
+	if _, found, _ := unstructured.NestedMap(apps.Object, SPEC, "template", SPEC, "syncPolicy", "automated"); found {
+		t.Errorf("Expected no automated policy under a RollingSync")
+	}
+	if patch, _, _ := unstructured.NestedString(apps.Object, SPEC, "templatePatch"); strings.Contains(patch, "automated") {
+		t.Errorf("Expected the templatePatch to leave automated out, got %s", patch)
+	}
+	steps, _, _ := unstructured.NestedSlice(apps.Object, SPEC, "strategy", "rollingSync", "steps")
+	expected := []string{"0", "1", "2", "3", "4"}
+	if len(steps) != len(expected) {
+		t.Fatalf("Expected a step per wave, got %v", steps)
+	}
+	for i, step := range steps {
+		exprs, _, _ := unstructured.NestedSlice(step.(map[string]interface{}), "matchExpressions")
+		values, _, _ := unstructured.NestedStringSlice(exprs[0].(map[string]interface{}), "values")
+		if values[0] != expected[i] {
+			t.Errorf("Expected step %d to roll out wave %s, got %v", i, expected[i], values)
+		}
+	}
+
+	if err := k.SetGenerator(GitGenerator, nil); err != nil {
+		t.Fatalf("SetGenerator failed %v", err)
+	}
+	if waves := k.waves(); waves[len(waves)-1] != 5 {
+		t.Errorf("Expected directories without a wave to roll out last, got %v", waves)
+	}
+}
+
+func TestSetWebhookSecret(t *testing.T) {
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(ARGOCD)
+	secret.SetName("argocd-secret")
+	k, _ := newFakeK8s(t, secret)
+	if value, err := k.WebhookSecret(); err != nil || value != "" {
+		t.Errorf("Expected no webhook secret yet, got %v %v", value, err)
+	}
+	if err := k.SetWebhookSecret("shared"); err != nil {
+		t.Fatalf("SetWebhookSecret failed %v", err)
+	}
+	if value, err := k.WebhookSecret(); err != nil || value != "shared" {
+		t.Errorf("Expected the gogs webhook secret to be set, got %v %v", value, err)
+	}
+}
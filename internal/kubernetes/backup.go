@@ -0,0 +1,222 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// BACKUP is the component running scheduled backups
+	BACKUP = "backup"
+
+	backupName = "pivot-backup"
+)
+
+// BackupOptions configures the scheduled backup CronJob.
+type BackupOptions struct {
+	// Schedule in cron format
+	Schedule string
+	// To is the s3://bucket/prefix/ archives are uploaded to
+	To         string
+	S3Endpoint string
+	S3Insecure bool
+	// S3AccessKey and S3SecretKey are stored in a Secret in the cluster
+	// only, never in the repo
+	S3AccessKey string
+	S3SecretKey string
+	// Image of pivot to run
+	Image string
+}
+
+var (
+	serviceAccountGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "serviceaccounts",
+	}
+	roleGVR = schema.GroupVersionResource{
+		Group:    "rbac.authorization.k8s.io",
+		Version:  "v1",
+		Resource: "roles",
+	}
+	roleBindingGVR = schema.GroupVersionResource{
+		Group:    "rbac.authorization.k8s.io",
+		Version:  "v1",
+		Resource: "rolebindings",
+	}
+	cronJobGVR = schema.GroupVersionResource{
+		Group:    "batch",
+		Version:  "v1",
+		Resource: "cronjobs",
+	}
+)
+
+// BackupComponent is the component running scheduled backups.
+func BackupComponent() Component {
+	return Component{
+		Path:      BACKUP,
+		Namespace: DEFAULT,
+		Prune:     true,
+		SelfHeal:  true,
+		Retry:     defaultRetry,
+		Wave:      4,
+	}
+}
+
+// CreateBackupSchedule creates a CronJob running pivot backup next to Gitea,
+// with a ServiceAccount only allowed to exec into its pods.
+func (k *K8s) CreateBackupSchedule(opts BackupOptions) error {
+	k.list[BACKUP] = []*unstructured.Unstructured{}
+	secret := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      backupName + "-s3",
+				NAMESPACE: DEFAULT,
+			},
+			"type": "Opaque",
+			"data": map[string]interface{}{
+				"AWS_ACCESS_KEY_ID":     base64.StdEncoding.EncodeToString([]byte(opts.S3AccessKey)),
+				"AWS_SECRET_ACCESS_KEY": base64.StdEncoding.EncodeToString([]byte(opts.S3SecretKey)),
+			},
+		},
+	}
+	// the credentials are not added to the list so they are never committed
+	if err := k.create(BACKUP, secretGVR, secret); err != nil {
+		return err
+	}
+
+	sa := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "ServiceAccount",
+			METADATA: map[string]interface{}{
+				NAME:      backupName,
+				NAMESPACE: DEFAULT,
+			},
+		},
+	}
+	role := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "rbac.authorization.k8s.io/v1",
+			KIND:       "Role",
+			METADATA: map[string]interface{}{
+				NAME:      backupName,
+				NAMESPACE: DEFAULT,
+			},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"apiGroups": []interface{}{""},
+					"resources": []interface{}{"pods"},
+					"verbs":     []interface{}{"get", "list"},
+				},
+				map[string]interface{}{
+					"apiGroups": []interface{}{""},
+					"resources": []interface{}{"pods/exec"},
+					"verbs":     []interface{}{"create"},
+				},
+			},
+		},
+	}
+	binding := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "rbac.authorization.k8s.io/v1",
+			KIND:       "RoleBinding",
+			METADATA: map[string]interface{}{
+				NAME:      backupName,
+				NAMESPACE: DEFAULT,
+			},
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				KIND:       "Role",
+				NAME:       backupName,
+			},
+			"subjects": []interface{}{
+				map[string]interface{}{
+					KIND:      "ServiceAccount",
+					NAME:      backupName,
+					NAMESPACE: DEFAULT,
+				},
+			},
+		},
+	}
+	args := []interface{}{"backup", "--to=" + opts.To, "--s3-endpoint=" + opts.S3Endpoint}
+	if opts.S3Insecure {
+		args = append(args, "--s3-insecure")
+	}
+	cronJob := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "batch/v1",
+			KIND:       "CronJob",
+			METADATA: map[string]interface{}{
+				NAME:      backupName,
+				NAMESPACE: DEFAULT,
+			},
+			SPEC: map[string]interface{}{
+				"schedule":          opts.Schedule,
+				"concurrencyPolicy": "Forbid",
+				"jobTemplate": map[string]interface{}{
+					SPEC: map[string]interface{}{
+						"backoffLimit": int64(2),
+						"template": map[string]interface{}{
+							SPEC: map[string]interface{}{
+								"serviceAccountName": backupName,
+								"restartPolicy":      "OnFailure",
+								"containers": []interface{}{
+									map[string]interface{}{
+										NAME:    "backup",
+										"image": opts.Image,
+										"args":  args,
+										"env": []interface{}{
+											// the archive is spooled to disk before uploading
+											map[string]interface{}{NAME: "TMPDIR", "value": "/work"},
+										},
+										"envFrom": []interface{}{
+											map[string]interface{}{
+												"secretRef": map[string]interface{}{NAME: backupName + "-s3"},
+											},
+										},
+										"volumeMounts": []interface{}{
+											map[string]interface{}{NAME: "work", "mountPath": "/work"},
+										},
+									},
+								},
+								"volumes": []interface{}{
+									map[string]interface{}{NAME: "work", "emptyDir": map[string]interface{}{}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, o := range []struct {
+		gvr schema.GroupVersionResource
+		obj *unstructured.Unstructured
+	}{
+		{serviceAccountGVR, sa},
+		{roleGVR, role},
+		{roleBindingGVR, binding},
+		{cronJobGVR, cronJob},
+	} {
+		k.list[BACKUP] = append(k.list[BACKUP], o.obj)
+		if err := k.create(BACKUP, o.gvr, o.obj); err != nil {
+			return err
+		}
+	}
+	return k.SaveInventory(BACKUP)
+}
+
+func (k *K8s) WriteBackupToFile(path string) error {
+	if len(k.list[BACKUP]) == 0 {
+		k.log.Error("no objects to write, you may need to run CreateBackupSchedule first")
+		return errors.New("no objects to write, you may need to run CreateBackupSchedule first")
+	}
+	return k.writeToFile(BACKUP, path)
+}
@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCreateBackupSchedule(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.CreateBackupSchedule(BackupOptions{
+		Schedule:    "0 3 * * *",
+		To:          "s3://backups/pivot/",
+		S3Endpoint:  "minio.example.com:9000",
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+		Image:       "ghcr.io/hyperspike/pivot:latest",
+	}); err != nil {
+		t.Fatalf("CreateBackupSchedule failed %v", err)
+	}
+	var cronJob *unstructured.Unstructured
+	for _, obj := range k.list[BACKUP] {
+		switch obj.GetKind() {
+		case "Secret":
+			t.Errorf("Expected the S3 credentials to never be written to the repo")
+		case "CronJob":
+			cronJob = obj
+		}
+	}
+	if cronJob == nil {
+		t.Fatalf("Expected a CronJob")
+	}
+	if schedule, _, _ := unstructured.NestedString(cronJob.Object, SPEC, "schedule"); schedule != "0 3 * * *" {
+		t.Errorf("Expected the schedule to be set, got %q", schedule)
+	}
+	if key, err := k.GetSecretValue(DEFAULT, backupName+"-s3", "AWS_SECRET_ACCESS_KEY"); err != nil || key != "secret" {
+		t.Errorf("Expected the S3 credentials in the cluster, got %v %v", key, err)
+	}
+	inv, err := k.GetInventory(BACKUP)
+	if err != nil || inv == nil || len(inv.Entries) != 5 {
+		t.Errorf("Expected the schedule in the backup inventory, got %v %v", inv, err)
+	}
+}
@@ -0,0 +1,294 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	goyaml "gopkg.in/yaml.v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ClusterAccess is the component of the objects granting Argo CD access
+	// to a workload cluster
+	ClusterAccess = "cluster-access"
+	// ClusterSecretLabel marks Argo CD cluster secrets, the cluster generator
+	// selects clusters by the other labels of the secret
+	ClusterSecretLabel = "argocd.argoproj.io/secret-type"
+
+	clusterManager   = "pivot-argocd-manager"
+	clusterNamespace = "kube-system"
+)
+
+var (
+	clusterRoleGVR = schema.GroupVersionResource{
+		Group:    "rbac.authorization.k8s.io",
+		Version:  "v1",
+		Resource: "clusterroles",
+	}
+	clusterRoleBindingGVR = schema.GroupVersionResource{
+		Group:    "rbac.authorization.k8s.io",
+		Version:  "v1",
+		Resource: "clusterrolebindings",
+	}
+)
+
+// ClusterCredentials is how Argo CD authenticates to a workload cluster.
+type ClusterCredentials struct {
+	Server string
+	Token  string
+	CAData []byte
+}
+
+// CreateClusterAccess creates a ServiceAccount with cluster-admin like access
+// for Argo CD in the cluster k points at, and returns the credentials of the
+// token bound to it.
+func (k *K8s) CreateClusterAccess() (*ClusterCredentials, error) {
+	sa := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "ServiceAccount",
+			METADATA: map[string]interface{}{
+				NAME:      clusterManager,
+				NAMESPACE: clusterNamespace,
+			},
+		},
+	}
+	role := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "rbac.authorization.k8s.io/v1",
+			KIND:       "ClusterRole",
+			METADATA: map[string]interface{}{
+				NAME: clusterManager,
+			},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"apiGroups": []interface{}{"*"},
+					"resources": []interface{}{"*"},
+					"verbs":     []interface{}{"*"},
+				},
+				map[string]interface{}{
+					"nonResourceURLs": []interface{}{"*"},
+					"verbs":           []interface{}{"*"},
+				},
+			},
+		},
+	}
+	binding := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "rbac.authorization.k8s.io/v1",
+			KIND:       "ClusterRoleBinding",
+			METADATA: map[string]interface{}{
+				NAME: clusterManager,
+			},
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				KIND:       "ClusterRole",
+				NAME:       clusterManager,
+			},
+			"subjects": []interface{}{
+				map[string]interface{}{
+					KIND:      "ServiceAccount",
+					NAME:      clusterManager,
+					NAMESPACE: clusterNamespace,
+				},
+			},
+		},
+	}
+	// a long lived token bound to the ServiceAccount, revoked by deleting it
+	token := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      clusterManager + "-token",
+				NAMESPACE: clusterNamespace,
+				"annotations": map[string]interface{}{
+					"kubernetes.io/service-account.name": clusterManager,
+				},
+			},
+			"type": "kubernetes.io/service-account-token",
+		},
+	}
+	for _, o := range []struct {
+		gvr schema.GroupVersionResource
+		obj *unstructured.Unstructured
+	}{
+		{serviceAccountGVR, sa},
+		{clusterRoleGVR, role},
+		{clusterRoleBindingGVR, binding},
+		{secretGVR, token},
+	} {
+		if err := k.create(ClusterAccess, o.gvr, o.obj); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.SaveInventory(ClusterAccess); err != nil {
+		return nil, err
+	}
+	if k.dryRun {
+		return &ClusterCredentials{}, nil
+	}
+
+	creds := &ClusterCredentials{}
+	if k.config != nil {
+		creds.Server = k.config.Host
+	}
+	// the token controller fills the secret in asynchronously
+	for tries := 0; ; tries++ {
+		t, err := k.GetSecretValue(clusterNamespace, clusterManager+"-token", "token")
+		ca, caErr := k.GetSecretValue(clusterNamespace, clusterManager+"-token", "ca.crt")
+		if err == nil && caErr == nil && t != "" {
+			creds.Token = t
+			creds.CAData = []byte(ca)
+			return creds, nil
+		}
+		if tries >= 30 {
+			return nil, fmt.Errorf("token of service account %s/%s was not issued", clusterNamespace, clusterManager)
+		}
+		time.Sleep(waitInterval / 5)
+	}
+}
+
+// CreateClusterSecret registers a workload cluster with Argo CD as name, the
+// labels are what cluster generators select it by. The secret holds the
+// bearer token, so it is never committed.
+func (k *K8s) CreateClusterSecret(name string, creds *ClusterCredentials, labels map[string]string) error {
+	config, err := json.Marshal(map[string]interface{}{
+		"bearerToken": creds.Token,
+		"tlsClientConfig": map[string]interface{}{
+			"insecure": false,
+			"caData":   base64.StdEncoding.EncodeToString(creds.CAData),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	data := map[string]string{
+		NAME:      name,
+		"server":  creds.Server,
+		"config":  string(config),
+		"project": PLATFORM,
+	}
+	l := map[string]interface{}{ClusterSecretLabel: "cluster"}
+	for key, value := range labels {
+		l[key] = value
+	}
+	encoded := map[string]interface{}{}
+	for key, value := range data {
+		encoded[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	secret := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      "cluster-" + name,
+				NAMESPACE: ARGOCD,
+				"labels":  l,
+			},
+			"type": "Opaque",
+			"data": encoded,
+		},
+	}
+	if err := k.create(ClusterAccess, secretGVR, secret); err != nil {
+		return err
+	}
+	// refresh the credentials and labels of a cluster added before
+	return k.updateClusterSecret("cluster-"+name, encoded, l)
+}
+
+// updateClusterSecret replaces the data and labels of the cluster secret
+// name, keeping the labels pivot tracks it by.
+func (k *K8s) updateClusterSecret(name string, data, labels map[string]interface{}) error {
+	if k.dryRun {
+		k.log.Infow("Dry run: Updating cluster secret", NAMESPACE, ARGOCD, NAME, name)
+		return nil
+	}
+	client := k.client.Resource(secretGVR).Namespace(ARGOCD)
+	existing, err := client.Get(k.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		k.log.Errorw("failed to get cluster secret", "error", err, NAMESPACE, ARGOCD, NAME, name)
+		return errors.Wrap(err, "")
+	}
+	patchLabels := map[string]interface{}{}
+	for key := range existing.GetLabels() {
+		switch key {
+		case PartOfLabel, ComponentLabel, RunIDLabel:
+		default:
+			patchLabels[key] = nil
+		}
+	}
+	for key, value := range labels {
+		patchLabels[key] = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		METADATA: map[string]interface{}{"labels": patchLabels},
+		"data":   data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if _, err := client.Patch(k.ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		k.log.Errorw("failed to update cluster secret", "error", err, NAMESPACE, ARGOCD, NAME, name)
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+// AddProjectDestination allows the platform AppProject in the init
+// manifests at path to deploy to any namespace of the cluster at server.
+// It reports whether the file changed.
+func AddProjectDestination(path, name, server string) (bool, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return false, errors.Wrap(err, "")
+	}
+	docs := strings.Split(string(data), "---\n")
+	changed := false
+	for i, doc := range docs {
+		obj := map[string]interface{}{}
+		if err := goyaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return false, errors.Wrap(err, "")
+		}
+		metadata, _ := obj[METADATA].(map[interface{}]interface{})
+		if obj[KIND] != "AppProject" || metadata == nil || metadata[NAME] != PLATFORM {
+			continue
+		}
+		spec, _ := obj[SPEC].(map[interface{}]interface{})
+		if spec == nil {
+			return false, fmt.Errorf("AppProject %s in %s has no spec", PLATFORM, path)
+		}
+		destinations, _ := spec["destinations"].([]interface{})
+		for _, d := range destinations {
+			if m, ok := d.(map[interface{}]interface{}); ok && m["server"] == server {
+				return false, nil
+			}
+		}
+		spec["destinations"] = append(destinations, map[string]interface{}{
+			NAME:      name,
+			NAMESPACE: "*",
+			"server":  server,
+		})
+		y, err := goyaml.Marshal(obj)
+		if err != nil {
+			return false, errors.Wrap(err, "")
+		}
+		docs[i] = string(y)
+		changed = true
+	}
+	if !changed {
+		return false, fmt.Errorf("no AppProject %s in %s", PLATFORM, path)
+	}
+	return true, errors.Wrap(os.WriteFile(filepath.Clean(path), []byte(strings.Join(docs, "---\n")), 0600), "")
+}
@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+)
+
+func TestCreateClusterAccess(t *testing.T) {
+	// the token controller has already issued the token
+	token := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      clusterManager + "-token",
+				NAMESPACE: clusterNamespace,
+			},
+			"data": map[string]interface{}{
+				"token":  base64.StdEncoding.EncodeToString([]byte("bearer")),
+				"ca.crt": base64.StdEncoding.EncodeToString([]byte("ca")),
+			},
+		},
+	}
+	target, _ := newFakeK8s(t, token)
+	creds, err := target.CreateClusterAccess()
+	if err != nil {
+		t.Fatalf("CreateClusterAccess failed %v", err)
+	}
+	if creds.Token != "bearer" || string(creds.CAData) != "ca" {
+		t.Errorf("Expected the issued token, got %+v", creds)
+	}
+	creds.Server = "https://workload.example.com:6443"
+
+	k, client := newFakeK8s(t)
+	if err := k.CreateClusterSecret("workload", creds, map[string]string{"env": "prod"}); err != nil {
+		t.Fatalf("CreateClusterSecret failed %v", err)
+	}
+	secret, err := client.Resource(secretGVR).Namespace(ARGOCD).Get(context.TODO(), "cluster-workload", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the cluster secret to be created %v", err)
+	}
+	if labels := secret.GetLabels(); labels[ClusterSecretLabel] != "cluster" || labels["env"] != "prod" {
+		t.Errorf("Expected the cluster labels, got %v", labels)
+	}
+	raw, err := k.GetSecretValue(ARGOCD, "cluster-workload", "config")
+	if err != nil {
+		t.Fatalf("Expected a cluster config %v", err)
+	}
+	config := struct {
+		BearerToken string `json:"bearerToken"`
+	}{}
+	if err := json.Unmarshal([]byte(raw), &config); err != nil || config.BearerToken != "bearer" {
+		t.Errorf("Expected the bearer token in the cluster config, got %s %v", raw, err)
+	}
+}
+
+func TestCreateClusterSecretUpdatesLabels(t *testing.T) {
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Secret",
+		METADATA: map[string]interface{}{
+			NAME:      "cluster-workload",
+			NAMESPACE: ARGOCD,
+			"labels": map[string]interface{}{
+				ClusterSecretLabel: "cluster",
+				PartOfLabel:        PIVOT,
+				ComponentLabel:     ClusterAccess,
+				"env":              "dev",
+				"region":           "eu",
+			},
+		},
+		"data": map[string]interface{}{"server": base64.StdEncoding.EncodeToString([]byte("https://old.example.com"))},
+	}}
+	k, client := newFakeK8s(t, existing)
+	creds := &ClusterCredentials{Server: "https://workload.example.com:6443", Token: "bearer"}
+	if err := k.CreateClusterSecret("workload", creds, map[string]string{"env": "prod"}); err != nil {
+		t.Fatalf("CreateClusterSecret failed %v", err)
+	}
+	secret, err := client.Resource(secretGVR).Namespace(ARGOCD).Get(context.TODO(), "cluster-workload", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the cluster secret %v", err)
+	}
+	labels := secret.GetLabels()
+	if labels["env"] != "prod" || labels[ClusterSecretLabel] != "cluster" || labels[ComponentLabel] != ClusterAccess {
+		t.Errorf("Expected the new cluster labels, got %v", labels)
+	}
+	if _, ok := labels["region"]; ok {
+		t.Errorf("Expected the dropped label to be removed, got %v", labels)
+	}
+	if server, _ := k.GetSecretValue(ARGOCD, "cluster-workload", "server"); server != creds.Server {
+		t.Errorf("Expected the server to be updated, got %s", server)
+	}
+}
+
+func TestAddProjectDestination(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.CreateArgoInit("", "pivot", "secret"); err != nil {
+		t.Fatalf("CreateArgoInit failed %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "init", "init.yaml")
+	if err := k.WriteArgoToFile(path); err != nil {
+		t.Fatalf("WriteArgoToFile failed %v", err)
+	}
+	server := "https://workload.example.com:6443"
+	changed, err := AddProjectDestination(path, "workload", server)
+	if err != nil || !changed {
+		t.Fatalf("Expected the destination to be added, got %v %v", changed, err)
+	}
+	if changed, err := AddProjectDestination(path, "workload", server); err != nil || changed {
+		t.Errorf("Expected adding the destination again to do nothing, got %v %v", changed, err)
+	}
+
+	objs := readObjects(t, path)
+	found := false
+	for _, obj := range objs {
+		if obj.GetKind() != "AppProject" {
+			continue
+		}
+		destinations, _, _ := unstructured.NestedSlice(obj.Object, SPEC, "destinations")
+		for _, d := range destinations {
+			if d.(map[string]interface{})["server"] == server {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s to be a destination of the platform project", server)
+	}
+	if len(objs) != len(k.list[ARGOCD]) {
+		t.Errorf("Expected the other manifests to be kept, got %d objects", len(objs))
+	}
+}
+
+// readObjects decodes the manifests written to path.
+func readObjects(t *testing.T, path string) []*unstructured.Unstructured {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+	objs := []*unstructured.Unstructured{}
+	for _, doc := range strings.Split(string(data), "---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decoder.Decode([]byte(doc), nil, obj); err != nil {
+			t.Fatalf("failed to decode %s: %v", path, err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
@@ -0,0 +1,183 @@
+package kubernetes
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Component is a directory of the infra repo that Argo CD deploys as its own
+// Application. Everything but the path is passed to the init ApplicationSet
+// as generator parameters, and with the git generator written to the
+// component's pivot.json.
+type Component struct {
+	// Path of the component in the infra repo, also the Application name
+	Path string `json:"-"`
+	// Namespace the component's namespaced resources are deployed to, the
+	// destination namespace of its Application
+	Namespace string `json:"namespace,omitempty"`
+	// SyncOptions of the Application, e.g. ServerSideApply=true
+	SyncOptions []string `json:"syncOptions,omitempty"`
+	// IgnoreDifferences lists fields Argo CD should not consider drift
+	IgnoreDifferences []IgnoreDifference `json:"ignoreDifferences,omitempty"`
+	// Prune deletes resources removed from the repo, SelfHeal reverts
+	// changes made in the cluster
+	Prune    bool `json:"prune,omitempty"`
+	SelfHeal bool `json:"selfHeal,omitempty"`
+	// Retry of failed syncs, nil to not retry
+	Retry *Retry `json:"retry,omitempty"`
+	// Wave orders the rollout, every Application of a wave is synced and
+	// healthy before the next wave starts
+	Wave int `json:"wave"`
+}
+
+// IgnoreDifference is an Argo CD resource ignore differences rule.
+type IgnoreDifference struct {
+	Group             string   `json:"group,omitempty"`
+	Kind              string   `json:"kind"`
+	Name              string   `json:"name,omitempty"`
+	Namespace         string   `json:"namespace,omitempty"`
+	JSONPointers      []string `json:"jsonPointers,omitempty"`
+	JQPathExpressions []string `json:"jqPathExpressions,omitempty"`
+}
+
+// Retry is an Argo CD sync retry strategy.
+type Retry struct {
+	Limit   int64    `json:"limit"`
+	Backoff *Backoff `json:"backoff,omitempty"`
+}
+
+// Backoff is the delay between sync retries, durations are Go durations.
+type Backoff struct {
+	Duration    string `json:"duration,omitempty"`
+	Factor      int64  `json:"factor,omitempty"`
+	MaxDuration string `json:"maxDuration,omitempty"`
+}
+
+// defaultRetry rides out components whose CRDs or webhooks are not ready yet.
+var defaultRetry = &Retry{
+	Limit: 5,
+	Backoff: &Backoff{
+		Duration:    "10s",
+		Factor:      2,
+		MaxDuration: "3m",
+	},
+}
+
+// caBundles ignores the caBundle cert-manager's cainjector writes into the
+// webhooks it serves.
+var caBundles = []IgnoreDifference{
+	{
+		Group:             "admissionregistration.k8s.io",
+		Kind:              "ValidatingWebhookConfiguration",
+		JQPathExpressions: []string{".webhooks[]?.clientConfig.caBundle"},
+	},
+	{
+		Group:             "admissionregistration.k8s.io",
+		Kind:              "MutatingWebhookConfiguration",
+		JQPathExpressions: []string{".webhooks[]?.clientConfig.caBundle"},
+	},
+}
+
+// DefaultComponents returns the components pivot bootstraps, in the order
+// they are applied. Their waves follow the same order, the operators share
+// one as they do not depend on each other.
+func DefaultComponents() []Component {
+	return []Component{
+		{
+			Path:              "cert-manager",
+			Namespace:         "cert-manager",
+			SyncOptions:       []string{"ServerSideApply=true"},
+			IgnoreDifferences: caBundles,
+			Retry:             defaultRetry,
+			Wave:              0,
+		},
+		{
+			Path:      ISSUERS,
+			Namespace: CertManager,
+			Prune:     true,
+			SelfHeal:  true,
+			Retry:     defaultRetry,
+			Wave:      1,
+		},
+		{
+			// the Argo CD CRDs are too large for client side apply
+			Path:        ARGOCD,
+			Namespace:   ARGOCD,
+			SyncOptions: []string{"ServerSideApply=true"},
+			Retry:       defaultRetry,
+			Wave:        1,
+		},
+		{
+			Path:        "postgres-operator",
+			Namespace:   "postgres-operator",
+			SyncOptions: []string{"CreateNamespace=true"},
+			SelfHeal:    true,
+			Retry:       defaultRetry,
+			Wave:        2,
+		},
+		{
+			Path:        "valkey-operator",
+			Namespace:   "valkey-operator",
+			SyncOptions: []string{"CreateNamespace=true"},
+			SelfHeal:    true,
+			Retry:       defaultRetry,
+			Wave:        2,
+		},
+		{
+			Path:        "gitea-operator",
+			Namespace:   "gitea-operator",
+			SyncOptions: []string{"CreateNamespace=true"},
+			SelfHeal:    true,
+			Retry:       defaultRetry,
+			Wave:        2,
+		},
+		{
+			Path:      GITEA,
+			Namespace: DEFAULT,
+			Prune:     true,
+			SelfHeal:  true,
+			Retry:     defaultRetry,
+			Wave:      3,
+		},
+		{
+			Path:      INIT,
+			Namespace: ARGOCD,
+			Prune:     true,
+			SelfHeal:  true,
+			Wave:      4,
+		},
+	}
+}
+
+// Params returns the ApplicationSet generator parameters of c, this is also
+// the content of its pivot.json.
+func (c Component) Params() (map[string]interface{}, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	params := map[string]interface{}{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	return params, nil
+}
+
+// Components returns the components Argo CD will deploy.
+func (k *K8s) Components() []Component {
+	return k.components
+}
+
+// AddComponent adds c to the components Argo CD deploys, replacing any
+// component with the same path.
+func (k *K8s) AddComponent(c Component) {
+	defer k.watchAdded()
+	for i := range k.components {
+		if k.components[i].Path == c.Path {
+			k.components[i] = c
+			return
+		}
+	}
+	k.components = append(k.components, c)
+}
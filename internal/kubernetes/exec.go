@@ -0,0 +1,68 @@
+package kubernetes
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Exec runs command in a container of namespace/pod, streaming its output to
+// stdout and stderr. An empty container runs in the pod's default container.
+func (k *K8s) Exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	config, client, err := k.coreClient("exec")
+	if err != nil {
+		return err
+	}
+	req := client.Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		k.log.Errorw("failed to create executor", "error", err)
+		return errors.Wrap(err, "")
+	}
+	k.log.Debugw("Executing", NAMESPACE, namespace, "pod", pod, "command", command)
+	if err := executor.StreamWithContext(k.ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	}); err != nil {
+		k.log.Errorw("command failed", "error", err, "pod", pod, "command", command)
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+// coreClient returns a REST client of the core API for what needs to stream
+// from pods, which the dynamic client cannot.
+func (k *K8s) coreClient(what string) (*rest.Config, *rest.RESTClient, error) {
+	if k.config == nil {
+		return nil, nil, errors.New(what + " needs a connection to the cluster")
+	}
+	config := rest.CopyConfig(k.config)
+	config.APIPath = "/api"
+	config.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	config.NegotiatedSerializer = serializer.WithoutConversionCodecFactory{CodecFactory: scheme.Codecs}
+	client, err := rest.RESTClientFor(config)
+	if err != nil {
+		k.log.Errorw("failed to create REST client", "error", err)
+		return nil, nil, errors.Wrap(err, "")
+	}
+	return config, client, nil
+}
@@ -0,0 +1,398 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ExposeKind selects how Gitea and Argo CD are reachable from outside the
+// cluster.
+type ExposeKind string
+
+const (
+	// NoExpose leaves exposing to the Gitea operator's own ingress
+	NoExpose ExposeKind = "none"
+	// IngressExpose creates an Ingress per service
+	IngressExpose ExposeKind = "ingress"
+	// GatewayExpose creates an HTTPRoute per service attached to a Gateway
+	GatewayExpose ExposeKind = "gateway"
+)
+
+// ExposeOptions configures the routes created for Gitea and Argo CD.
+type ExposeOptions struct {
+	Kind ExposeKind
+	// ArgoHost is the hostname of Argo CD, Gitea uses its domain
+	ArgoHost string
+	// IngressClass of the Ingresses, the cluster default when empty
+	IngressClass string
+	// Gateway and GatewayNamespace name the Gateway routes attach to
+	Gateway          string
+	GatewayNamespace string
+	// GatewayClass creates the Gateway with this class, when empty the
+	// Gateway must already exist
+	GatewayClass string
+	// BackendCA is the PEM CA a Gateway verifies the certificates of Gitea
+	// and Argo CD with, the system CAs when empty
+	BackendCA []byte
+}
+
+const (
+	// GATEWAY is the component holding a Gateway pivot creates
+	GATEWAY = "gateway"
+	// argoServer is the Service of the Argo CD UI and API
+	argoServer = "argocd-server"
+	// argoServerTLS is the Secret Argo CD serves its UI and API with
+	argoServerTLS = "argocd-server-tls"
+	// backendCA is the ConfigMap holding BackendCA next to each route
+	backendCA = "pivot-ca"
+)
+
+// backendProtocol are the annotations making an ingress controller connect
+// to its backends over HTTPS, by ingress class. Controllers not listed have
+// to be configured by hand.
+var backendProtocol = map[string]map[string]interface{}{
+	"nginx": {
+		"nginx.ingress.kubernetes.io/backend-protocol": "HTTPS",
+	},
+	"haproxy": {
+		"haproxy.org/server-ssl": "true",
+	},
+}
+
+var (
+	ingressGVR = schema.GroupVersionResource{
+		Group:    "networking.k8s.io",
+		Version:  "v1",
+		Resource: "ingresses",
+	}
+	backendTLSPolicyGVR = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1",
+		Resource: "backendtlspolicies",
+	}
+	gatewayGVR = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1",
+		Resource: "gateways",
+	}
+	httpRouteGVR = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1",
+		Resource: "httproutes",
+	}
+)
+
+// SetExpose selects how CreateGitea and CreateArgoInit expose Gitea and
+// Argo CD.
+func (k *K8s) SetExpose(opts ExposeOptions) error {
+	switch opts.Kind {
+	case "":
+		opts.Kind = NoExpose
+	case NoExpose, IngressExpose:
+	case GatewayExpose:
+		if opts.Gateway == "" {
+			return fmt.Errorf("exposing with a gateway needs a gateway name")
+		}
+		if opts.GatewayNamespace == "" {
+			opts.GatewayNamespace = DEFAULT
+		}
+		// a Gateway pivot creates is a component of its own, so kustomize
+		// keeps it in its namespace
+		if opts.GatewayClass != "" {
+			k.AddComponent(Component{
+				Path:        GATEWAY,
+				Namespace:   opts.GatewayNamespace,
+				SyncOptions: []string{"CreateNamespace=true"},
+				Prune:       true,
+				SelfHeal:    true,
+				Retry:       defaultRetry,
+				Wave:        3,
+			})
+		}
+	default:
+		return fmt.Errorf("unknown expose %q, expected %s, %s or %s", opts.Kind, NoExpose, IngressExpose, GatewayExpose)
+	}
+	k.expose = opts
+	return nil
+}
+
+// Expose returns how Gitea and Argo CD are exposed.
+func (k *K8s) Expose() ExposeOptions {
+	return k.expose
+}
+
+// exposeService routes host to the HTTPS port of service, the objects are
+// added to list and the inventory of component. A Gateway originates TLS to
+// service as set by a BackendTLSPolicy.
+func (k *K8s) exposeService(component, list, namespace, service, host string) error {
+	objects := []*unstructured.Unstructured{}
+	gvrs := []schema.GroupVersionResource{}
+	switch k.expose.Kind {
+	case IngressExpose:
+		objects = append(objects, k.ingress(namespace, service, host))
+		gvrs = append(gvrs, ingressGVR)
+	case GatewayExpose:
+		if len(k.expose.BackendCA) > 0 {
+			objects = append(objects, caConfigMap(namespace, k.expose.BackendCA))
+			gvrs = append(gvrs, configMapGVR)
+		}
+		objects = append(objects, k.backendTLSPolicy(namespace, service, host), k.httpRoute(namespace, service, host))
+		gvrs = append(gvrs, backendTLSPolicyGVR, httpRouteGVR)
+	}
+	for i, obj := range objects {
+		k.list[list] = append(k.list[list], obj)
+		if err := k.create(component, gvrs[i], obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *K8s) ingress(namespace, service, host string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"tls": []interface{}{
+			map[string]interface{}{
+				"hosts":      []interface{}{host},
+				"secretName": service + "-tls",
+			},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"host": host,
+				"http": map[string]interface{}{
+					"paths": []interface{}{
+						map[string]interface{}{
+							PATH:       "/",
+							"pathType": "Prefix",
+							"backend": map[string]interface{}{
+								"service": map[string]interface{}{
+									NAME:   service,
+									"port": map[string]interface{}{"number": int64(443)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if k.expose.IngressClass != "" {
+		spec["ingressClassName"] = k.expose.IngressClass
+	}
+	annotations := map[string]interface{}{
+		"cert-manager.io/cluster-issuer": IssuerName,
+	}
+	// both Gitea and Argo CD only serve HTTPS, the default class is assumed
+	// to be ingress-nginx
+	class := k.expose.IngressClass
+	if class == "" {
+		class = "nginx"
+	}
+	for key, value := range backendProtocol[class] {
+		annotations[key] = value
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "networking.k8s.io/v1",
+			KIND:       "Ingress",
+			METADATA: map[string]interface{}{
+				NAME:          service,
+				NAMESPACE:     namespace,
+				"annotations": annotations,
+			},
+			SPEC: spec,
+		},
+	}
+}
+
+// caConfigMap holds the CA a BackendTLSPolicy verifies backends with.
+func caConfigMap(namespace string, ca []byte) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "ConfigMap",
+			METADATA: map[string]interface{}{
+				NAME:      backendCA,
+				NAMESPACE: namespace,
+			},
+			"data": map[string]interface{}{
+				"ca.crt": string(ca),
+			},
+		},
+	}
+}
+
+// backendTLSPolicy makes a Gateway connect to service over TLS, verifying
+// its certificate for host with BackendCA, or the system CAs without one.
+func (k *K8s) backendTLSPolicy(namespace, service, host string) *unstructured.Unstructured {
+	validation := map[string]interface{}{
+		"hostname": host,
+	}
+	if len(k.expose.BackendCA) > 0 {
+		validation["caCertificateRefs"] = []interface{}{
+			map[string]interface{}{"group": "", KIND: "ConfigMap", NAME: backendCA},
+		}
+	} else {
+		validation["wellKnownCACertificates"] = "System"
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "gateway.networking.k8s.io/v1",
+			KIND:       "BackendTLSPolicy",
+			METADATA: map[string]interface{}{
+				NAME:      service,
+				NAMESPACE: namespace,
+			},
+			SPEC: map[string]interface{}{
+				"targetRefs": []interface{}{
+					map[string]interface{}{"group": "", KIND: "Service", NAME: service},
+				},
+				"validation": validation,
+			},
+		},
+	}
+}
+
+// argoCertificate issues the certificate Argo CD serves with from the pivot
+// issuer, for its external host and in-cluster names, replacing the one it
+// signs itself.
+func argoCertificate(host string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "cert-manager.io/v1",
+			KIND:       "Certificate",
+			METADATA: map[string]interface{}{
+				NAME:      argoServerTLS,
+				NAMESPACE: ARGOCD,
+			},
+			SPEC: map[string]interface{}{
+				"secretName": argoServerTLS,
+				"dnsNames": []interface{}{
+					host,
+					argoServer,
+					argoServer + "." + ARGOCD + ".svc",
+					argoServer + "." + ARGOCD + ".svc.cluster.local",
+				},
+				"issuerRef": map[string]interface{}{
+					NAME:    IssuerName,
+					KIND:    "ClusterIssuer",
+					"group": "cert-manager.io",
+				},
+			},
+		},
+	}
+}
+
+func (k *K8s) httpRoute(namespace, service, host string) *unstructured.Unstructured {
+	parent := map[string]interface{}{
+		NAME:      k.expose.Gateway,
+		NAMESPACE: k.expose.GatewayNamespace,
+	}
+	// the listeners of a Gateway pivot creates are named after the services
+	if k.expose.GatewayClass != "" {
+		parent["sectionName"] = service
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "gateway.networking.k8s.io/v1",
+			KIND:       "HTTPRoute",
+			METADATA: map[string]interface{}{
+				NAME:      service,
+				NAMESPACE: namespace,
+			},
+			SPEC: map[string]interface{}{
+				"parentRefs": []interface{}{parent},
+				"hostnames":  []interface{}{host},
+				"rules": []interface{}{
+					map[string]interface{}{
+						"backendRefs": []interface{}{
+							map[string]interface{}{
+								NAME:   service,
+								"port": int64(443),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createGateway creates the Gateway routes attach to when it is managed by
+// pivot, with an HTTPS listener for Gitea and one for Argo CD.
+func (k *K8s) createGateway(giteaHost string) error {
+	if k.expose.Kind != GatewayExpose || k.expose.GatewayClass == "" {
+		return nil
+	}
+	k.list[GATEWAY] = []*unstructured.Unstructured{}
+	k.applied[GATEWAY] = nil
+	// namespaced, a cluster admin creates the namespace
+	if k.expose.GatewayNamespace != DEFAULT && !k.namespaced {
+		if err := k.CreateNamespace(k.expose.GatewayNamespace); err != nil {
+			return err
+		}
+	}
+	gw := k.gateway(map[string]string{GITEA: giteaHost, argoServer: k.expose.ArgoHost})
+	k.list[GATEWAY] = append(k.list[GATEWAY], gw)
+	if err := k.create(GATEWAY, gatewayGVR, gw); err != nil {
+		return err
+	}
+	return k.SaveInventory(GATEWAY)
+}
+
+// WriteGatewayToFile writes the Gateway created by CreateGitea to path.
+func (k *K8s) WriteGatewayToFile(path string) error {
+	if len(k.list[GATEWAY]) == 0 {
+		k.log.Error("no objects to write, you may need to run CreateGitea with a gateway class first")
+		return errors.New("no objects to write, you may need to run CreateGitea with a gateway class first")
+	}
+	return k.writeToFile(GATEWAY, path)
+}
+
+// gateway builds a Gateway with an HTTPS listener per host, named after the
+// service it routes to. cert-manager issues the listener certificates.
+func (k *K8s) gateway(hosts map[string]string) *unstructured.Unstructured {
+	listeners := []interface{}{}
+	for _, service := range []string{GITEA, argoServer} {
+		host, ok := hosts[service]
+		if !ok || host == "" {
+			continue
+		}
+		listeners = append(listeners, map[string]interface{}{
+			NAME:       service,
+			"hostname": host,
+			"port":     int64(443),
+			"protocol": "HTTPS",
+			"tls": map[string]interface{}{
+				"mode": "Terminate",
+				"certificateRefs": []interface{}{
+					map[string]interface{}{NAME: service + "-tls"},
+				},
+			},
+			"allowedRoutes": map[string]interface{}{
+				"namespaces": map[string]interface{}{"from": "All"},
+			},
+		})
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "gateway.networking.k8s.io/v1",
+			KIND:       "Gateway",
+			METADATA: map[string]interface{}{
+				NAME:      k.expose.Gateway,
+				NAMESPACE: k.expose.GatewayNamespace,
+				"annotations": map[string]interface{}{
+					"cert-manager.io/cluster-issuer": IssuerName,
+				},
+			},
+			SPEC: map[string]interface{}{
+				"gatewayClassName": k.expose.GatewayClass,
+				"listeners":        listeners,
+			},
+		},
+	}
+}
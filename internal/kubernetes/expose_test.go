@@ -0,0 +1,149 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestExposeIngress(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.SetExpose(ExposeOptions{Kind: IngressExpose, ArgoHost: "argocd.example.com", IngressClass: "nginx"}); err != nil {
+		t.Fatalf("SetExpose failed %v", err)
+	}
+	if err := k.CreateGitea("", GiteaOptions{User: "alice", Password: "secret", Domain: "git.example.com"}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+	if err := k.CreateArgoInit("", DeployUser, ""); err != nil {
+		t.Fatalf("CreateArgoInit failed %v", err)
+	}
+	for _, want := range []struct{ namespace, name, host string }{
+		{DEFAULT, GITEA, "git.example.com"},
+		{ARGOCD, argoServer, "argocd.example.com"},
+	} {
+		ingress, err := client.Resource(ingressGVR).Namespace(want.namespace).Get(context.TODO(), want.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected an ingress for %s %v", want.name, err)
+		}
+		if class, _, _ := unstructured.NestedString(ingress.Object, SPEC, "ingressClassName"); class != "nginx" {
+			t.Errorf("Expected ingress class nginx, got %v", class)
+		}
+		if issuer := ingress.GetAnnotations()["cert-manager.io/cluster-issuer"]; issuer != IssuerName {
+			t.Errorf("Expected certificates from %s, got %v", IssuerName, issuer)
+		}
+		if ingress.GetAnnotations()["nginx.ingress.kubernetes.io/backend-protocol"] != "HTTPS" {
+			t.Errorf("Expected ingress-nginx to connect over HTTPS, got %v", ingress.GetAnnotations())
+		}
+		rules, _, _ := unstructured.NestedSlice(ingress.Object, SPEC, "rules")
+		if rules[0].(map[string]interface{})["host"] != want.host {
+			t.Errorf("Expected host %s, got %v", want.host, rules[0])
+		}
+	}
+	gitea, err := client.Resource(giteaGVR).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the gitea CR %v", err)
+	}
+	if _, found, _ := unstructured.NestedMap(gitea.Object, SPEC, "ingress"); found {
+		t.Errorf("Expected the operator not to create a second ingress, got %v", gitea.Object[SPEC])
+	}
+	cert, err := client.Resource(certificateGVR).Namespace(ARGOCD).Get(context.TODO(), argoServerTLS, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected a certificate for argocd-server %v", err)
+	}
+	if names, _, _ := unstructured.NestedStringSlice(cert.Object, SPEC, "dnsNames"); names[0] != "argocd.example.com" {
+		t.Errorf("Expected the certificate to cover the argocd host, got %v", names)
+	}
+}
+
+func TestIngressBackendProtocol(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.SetExpose(ExposeOptions{Kind: IngressExpose, IngressClass: "haproxy"}); err != nil {
+		t.Fatalf("SetExpose failed %v", err)
+	}
+	annotations := k.ingress(DEFAULT, GITEA, "git.example.com").GetAnnotations()
+	if annotations["haproxy.org/server-ssl"] != "true" {
+		t.Errorf("Expected the haproxy annotation, got %v", annotations)
+	}
+	if _, ok := annotations["nginx.ingress.kubernetes.io/backend-protocol"]; ok {
+		t.Errorf("Expected no ingress-nginx annotation for haproxy, got %v", annotations)
+	}
+}
+
+func TestExposeGateway(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.SetExpose(ExposeOptions{Kind: GatewayExpose}); err == nil {
+		t.Errorf("Expected a gateway without a name to be rejected")
+	}
+	if err := k.SetExpose(ExposeOptions{Kind: GatewayExpose, Gateway: "edge", GatewayClass: "cilium", ArgoHost: "argocd.example.com"}); err != nil {
+		t.Fatalf("SetExpose failed %v", err)
+	}
+	if err := k.CreateGitea("", GiteaOptions{User: "alice", Password: "secret", Domain: "git.example.com"}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+	gw, err := client.Resource(gatewayGVR).Namespace(DEFAULT).Get(context.TODO(), "edge", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the gateway to be created %v", err)
+	}
+	if listeners, _, _ := unstructured.NestedSlice(gw.Object, SPEC, "listeners"); len(listeners) != 2 {
+		t.Errorf("Expected a listener for gitea and argocd, got %v", listeners)
+	}
+	route, err := client.Resource(httpRouteGVR).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected a route for gitea %v", err)
+	}
+	parents, _, _ := unstructured.NestedSlice(route.Object, SPEC, "parentRefs")
+	if parents[0].(map[string]interface{})["sectionName"] != GITEA {
+		t.Errorf("Expected the route to attach to the gitea listener, got %v", parents)
+	}
+	policy, err := client.Resource(backendTLSPolicyGVR).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected a backend TLS policy for gitea %v", err)
+	}
+	if ca, _, _ := unstructured.NestedString(policy.Object, SPEC, "validation", "wellKnownCACertificates"); ca != "System" {
+		t.Errorf("Expected the system CAs without a backend CA, got %v", policy.Object)
+	}
+}
+
+func TestExposeGatewayNamespace(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.SetExpose(ExposeOptions{
+		Kind:             GatewayExpose,
+		Gateway:          "edge",
+		GatewayNamespace: "ingress",
+		GatewayClass:     "cilium",
+		BackendCA:        []byte("ca"),
+	}); err != nil {
+		t.Fatalf("SetExpose failed %v", err)
+	}
+	found := false
+	for _, c := range k.Components() {
+		if c.Path == GATEWAY {
+			found = c.Namespace == "ingress"
+		}
+	}
+	if !found {
+		t.Errorf("Expected the gateway to be a component in its namespace, got %v", k.Components())
+	}
+	if err := k.CreateGitea("", GiteaOptions{User: "alice", Password: "secret", Domain: "git.example.com"}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+	if _, err := client.Resource(gatewayGVR).Namespace("ingress").Get(context.TODO(), "edge", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the gateway in the ingress namespace %v", err)
+	}
+	if len(k.list[GATEWAY]) != 1 {
+		t.Errorf("Expected the gateway to be written on its own, got %v", k.list[GATEWAY])
+	}
+	policy, err := client.Resource(backendTLSPolicyGVR).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected a backend TLS policy for gitea %v", err)
+	}
+	refs, _, _ := unstructured.NestedSlice(policy.Object, SPEC, "validation", "caCertificateRefs")
+	if len(refs) != 1 || refs[0].(map[string]interface{})[NAME] != backendCA {
+		t.Errorf("Expected the backend CA to be referenced, got %v", refs)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace(DEFAULT).Get(context.TODO(), backendCA, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the backend CA next to the route %v", err)
+	}
+}
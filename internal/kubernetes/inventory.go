@@ -0,0 +1,280 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	PIVOT = "pivot"
+
+	PartOfLabel    = "app.kubernetes.io/part-of"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ComponentLabel = "pivot.hyperspike.io/component"
+	RunIDLabel     = "pivot.hyperspike.io/run-id"
+
+	inventoryPrefix = "pivot-inventory-"
+	inventoryKey    = "inventory.json"
+)
+
+var (
+	configMapGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "configmaps",
+	}
+	secretGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "secrets",
+	}
+)
+
+// InventoryEntry identifies a single object applied by pivot.
+type InventoryEntry struct {
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (e InventoryEntry) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: e.Group, Version: e.Version, Resource: e.Resource}
+}
+
+// Inventory is the record of everything pivot applied for a component, it is
+// stored in a ConfigMap named pivot-inventory-<component>.
+type Inventory struct {
+	Component string           `json:"component"`
+	RunID     string           `json:"runId"`
+	Revision  string           `json:"revision,omitempty"`
+	Version   string           `json:"version,omitempty"`
+	Entries   []InventoryEntry `json:"entries"`
+}
+
+// RunID returns the identifier stamped onto every object applied by k.
+func (k *K8s) RunID() string {
+	return k.runID
+}
+
+// SetSource records the infra repo revision being applied and the upstream
+// version of each component, both are saved alongside the inventory.
+func (k *K8s) SetSource(revision string, versions map[string]string) {
+	k.revision = revision
+	for c, v := range versions {
+		k.versions[c] = v
+	}
+}
+
+func (k *K8s) labelObject(component string, obj *unstructured.Unstructured) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[PartOfLabel] = PIVOT
+	if _, ok := labels[ManagedByLabel]; !ok {
+		labels[ManagedByLabel] = PIVOT
+	}
+	labels[ComponentLabel] = component
+	labels[RunIDLabel] = k.runID
+	obj.SetLabels(labels)
+}
+
+// relabel stamps the pivot labels of obj onto the existing object.
+func (k *K8s) relabel(client dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		METADATA: map[string]interface{}{
+			"labels": map[string]string{
+				PartOfLabel:    obj.GetLabels()[PartOfLabel],
+				ComponentLabel: obj.GetLabels()[ComponentLabel],
+				RunIDLabel:     obj.GetLabels()[RunIDLabel],
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if _, err := client.Patch(k.ctx, obj.GetName(), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		k.log.Errorw("failed to relabel resource", "error", err, KIND, obj.GetKind(), NAME, obj.GetName())
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+func (k *K8s) track(component string, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) {
+	k.applied[component] = append(k.applied[component], InventoryEntry{
+		Group:     gvr.Group,
+		Version:   gvr.Version,
+		Kind:      obj.GetKind(),
+		Resource:  gvr.Resource,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	})
+}
+
+// GetInventory reads the stored inventory of component, returning nil if
+// pivot has never applied it.
+func (k *K8s) GetInventory(component string) (*Inventory, error) {
+	cm, err := k.client.Resource(configMapGVR).Namespace(DEFAULT).Get(k.ctx, inventoryPrefix+component, metav1.GetOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return nil, nil
+	} else if err != nil {
+		k.log.Errorw("failed to get inventory", "error", err, "component", component)
+		return nil, errors.Wrap(err, "")
+	}
+	return decodeInventory(cm)
+}
+
+// ListInventories returns the inventory of every component pivot has applied.
+func (k *K8s) ListInventories() ([]*Inventory, error) {
+	list, err := k.client.Resource(configMapGVR).Namespace(DEFAULT).List(k.ctx, metav1.ListOptions{
+		LabelSelector: PartOfLabel + "=" + PIVOT + "," + ComponentLabel,
+	})
+	if err != nil {
+		k.log.Errorw("failed to list inventories", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
+	inventories := []*Inventory{}
+	for i := range list.Items {
+		if !strings.HasPrefix(list.Items[i].GetName(), inventoryPrefix) {
+			continue
+		}
+		inv, err := decodeInventory(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		inventories = append(inventories, inv)
+	}
+	return inventories, nil
+}
+
+func decodeInventory(cm *unstructured.Unstructured) (*Inventory, error) {
+	data, _, err := unstructured.NestedString(cm.Object, "data", inventoryKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	inv := &Inventory{}
+	if err := json.Unmarshal([]byte(data), inv); err != nil {
+		return nil, errors.Wrap(err, "failed to decode inventory "+cm.GetName())
+	}
+	return inv, nil
+}
+
+// SaveInventory prunes the objects recorded by the previous run of component
+// that were not applied by this one, then replaces the stored inventory with
+// the objects applied during this run. Objects that failed to prune stay in
+// the inventory so the next run prunes them again. The ConfigMap is written
+// in a single update guarded by its resourceVersion, so concurrent runs
+// cannot interleave.
+func (k *K8s) SaveInventory(component string) error {
+	inv := &Inventory{
+		Component: component,
+		RunID:     k.runID,
+		Revision:  k.revision,
+		Version:   k.versions[component],
+		Entries:   k.applied[component],
+	}
+	if k.dryRun {
+		k.log.Infow("Dry run: Saving inventory", "component", component, "objects", len(inv.Entries))
+		return nil
+	}
+	previous, err := k.GetInventory(component)
+	if err != nil {
+		return err
+	}
+	var pruneErr error
+	if previous != nil {
+		var unpruned []InventoryEntry
+		unpruned, pruneErr = k.prune(previous, inv)
+		inv.Entries = append(append([]InventoryEntry{}, inv.Entries...), unpruned...)
+	}
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	cm := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "ConfigMap",
+			METADATA: map[string]interface{}{
+				NAME:      inventoryPrefix + component,
+				NAMESPACE: DEFAULT,
+			},
+			"data": map[string]interface{}{
+				inventoryKey: string(data),
+			},
+		},
+	}
+	k.labelObject(component, cm)
+	client := k.client.Resource(configMapGVR).Namespace(DEFAULT)
+	existing, err := client.Get(k.ctx, cm.GetName(), metav1.GetOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		k.log.Infow("Creating inventory", "component", component, "objects", len(inv.Entries))
+		_, err = client.Create(k.ctx, cm, metav1.CreateOptions{})
+	} else if err == nil {
+		k.log.Infow("Updating inventory", "component", component, "objects", len(inv.Entries))
+		cm.SetResourceVersion(existing.GetResourceVersion())
+		_, err = client.Update(k.ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		k.log.Errorw("failed to save inventory", "error", err, "component", component)
+		return errors.Wrap(err, "")
+	}
+	return pruneErr
+}
+
+// objectKey identifies an object across API versions, an object moved to a
+// new apiVersion upstream is still the same object.
+type objectKey struct {
+	group, kind, namespace, name string
+}
+
+func (e InventoryEntry) key() objectKey {
+	return objectKey{e.Group, e.Kind, e.Namespace, e.Name}
+}
+
+// prune deletes the objects in previous that are not part of current and
+// returns those it failed to delete, along with the first error. Namespaces
+// and CRDs are never pruned as deleting them cascades to data pivot does not
+// own.
+func (k *K8s) prune(previous, current *Inventory) ([]InventoryEntry, error) {
+	var unpruned []InventoryEntry
+	var first error
+	keep := map[objectKey]bool{}
+	for _, e := range current.Entries {
+		keep[e.key()] = true
+	}
+	for _, e := range previous.Entries {
+		if keep[e.key()] {
+			continue
+		}
+		if e.Kind == "Namespace" || e.Kind == "CustomResourceDefinition" {
+			k.log.Warnw("Not pruning resource, remove it manually", KIND, e.Kind, NAME, e.Name)
+			continue
+		}
+		k.log.Infow("Pruning resource", NAMESPACE, e.Namespace, KIND, e.Kind, NAME, e.Name)
+		var client dynamic.ResourceInterface = k.client.Resource(e.gvr())
+		if e.Namespace != "" {
+			client = k.client.Resource(e.gvr()).Namespace(e.Namespace)
+		}
+		err := client.Delete(k.ctx, e.Name, metav1.DeleteOptions{})
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			k.log.Errorw("failed to prune resource", "error", err, NAMESPACE, e.Namespace, KIND, e.Kind)
+			unpruned = append(unpruned, e)
+			if first == nil {
+				first = errors.Wrap(err, "")
+			}
+		}
+	}
+	return unpruned, first
+}
@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestInventoryPrune(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "demo")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("kustomization.yaml", "namespace: demo\nresources:\n- one.yaml\n- two.yaml\n")
+	write("one.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: one\n")
+	write("two.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: two\n")
+
+	k, client := newFakeK8s(t)
+	k.SetSource("abc123", map[string]string{"demo": "v1.0.0"})
+	if err := k.ApplyKustomize(dir); err != nil {
+		t.Fatalf("ApplyKustomize failed %v", err)
+	}
+	inv, err := k.GetInventory("demo")
+	if err != nil || inv == nil {
+		t.Fatalf("Expected demo inventory %v", err)
+	}
+	if len(inv.Entries) != 2 || inv.Revision != "abc123" || inv.Version != "v1.0.0" {
+		t.Errorf("Unexpected inventory %+v", inv)
+	}
+
+	// a second run without two.yaml prunes it
+	write("kustomization.yaml", "namespace: demo\nresources:\n- one.yaml\n")
+	second := NewK8sForClient(context.TODO(), zap.NewNop().Sugar(), client, nil, k.mapper)
+	if err := second.ApplyKustomize(dir); err != nil {
+		t.Fatalf("ApplyKustomize failed %v", err)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("demo").Get(context.TODO(), "two", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected configmap two to be pruned")
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("demo").Get(context.TODO(), "one", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected configmap one to be kept %v", err)
+	}
+	inventories, err := second.ListInventories()
+	if err != nil {
+		t.Fatalf("ListInventories failed %v", err)
+	}
+	if len(inventories) != 1 || len(inventories[0].Entries) != 1 {
+		t.Errorf("Expected a single inventory with one entry, got %+v", inventories)
+	}
+}
+
+func TestPruneKeepsObjectsMovedToANewVersion(t *testing.T) {
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetName("one")
+	cm.SetNamespace("demo")
+	k, client := newFakeK8s(t, cm)
+	entry := InventoryEntry{Version: "v1", Kind: "ConfigMap", Resource: "configmaps", Namespace: "demo", Name: "one"}
+	old := entry
+	old.Version = "v1beta1"
+	if _, err := k.prune(&Inventory{Entries: []InventoryEntry{old}}, &Inventory{Entries: []InventoryEntry{entry}}); err != nil {
+		t.Fatalf("prune failed %v", err)
+	}
+	if _, err := client.Resource(configMapGVR).Namespace("demo").Get(context.TODO(), "one", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected configmap one to be kept across versions %v", err)
+	}
+}
+
+func TestSaveInventoryKeepsUnpruned(t *testing.T) {
+	k, client := newFakeK8s(t)
+	k.applied["demo"] = []InventoryEntry{{Version: "v1", Kind: "ConfigMap", Resource: "configmaps", Namespace: "demo", Name: "one"}}
+	if err := k.SaveInventory("demo"); err != nil {
+		t.Fatalf("SaveInventory failed %v", err)
+	}
+	client.PrependReactor("delete", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("boom")
+	})
+	k.applied["demo"] = []InventoryEntry{{Version: "v1", Kind: "ConfigMap", Resource: "configmaps", Namespace: "demo", Name: "two"}}
+	if err := k.SaveInventory("demo"); err == nil {
+		t.Fatalf("Expected the failed prune to be returned")
+	}
+	inv, err := k.GetInventory("demo")
+	if err != nil || inv == nil {
+		t.Fatalf("Expected the inventory to be saved despite the failed prune, got %v", err)
+	}
+	names := []string{}
+	for _, e := range inv.Entries {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "two,one" {
+		t.Errorf("Expected the unpruned object to stay in the inventory, got %v", names)
+	}
+
+	// once the delete succeeds the object is pruned and dropped
+	client.ReactionChain = client.ReactionChain[1:]
+	if err := k.SaveInventory("demo"); err != nil {
+		t.Fatalf("SaveInventory failed %v", err)
+	}
+	if inv, err := k.GetInventory("demo"); err != nil || len(inv.Entries) != 1 || inv.Entries[0].Name != "two" {
+		t.Errorf("Expected only the applied object once pruned, got %+v %v", inv, err)
+	}
+}
@@ -0,0 +1,242 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ISSUERS is the component holding the cert-manager issuers
+	ISSUERS = "issuers"
+	// IssuerName is the ClusterIssuer signing every certificate pivot requests
+	IssuerName = PIVOT
+	// CertManager is the namespace cert-manager reads ClusterIssuer secrets from
+	CertManager = "cert-manager"
+
+	caSecret          = "pivot-ca"
+	selfSignedIssuer  = "pivot-selfsigned"
+	acmeAccountSecret = "pivot-acme-account"
+
+	// LetsEncrypt is the default ACME directory
+	LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+)
+
+// IssuerKind selects how the pivot ClusterIssuer signs certificates.
+type IssuerKind string
+
+const (
+	// SelfSignedIssuer signs with a CA generated by cert-manager
+	SelfSignedIssuer IssuerKind = "selfsigned"
+	// CAIssuer signs with a user supplied CA keypair
+	CAIssuer IssuerKind = "ca"
+	// ACMEIssuer requests certificates from an ACME directory
+	ACMEIssuer IssuerKind = "acme"
+)
+
+// IssuerOptions configures the issuers created by CreateIssuers.
+type IssuerOptions struct {
+	Kind IssuerKind
+	// CACert and CAKey are the PEM encoded keypair of a CAIssuer, the key is
+	// stored in the cluster only, never in the repo
+	CACert []byte
+	CAKey  []byte
+	// ACMEServer is the directory URL of an ACMEIssuer and ACMEEmail the
+	// account contact
+	ACMEServer string
+	ACMEEmail  string
+	// ACMESkipTLSVerify trusts any certificate of the ACME server, such as
+	// a local Pebble
+	ACMESkipTLSVerify bool
+	// IngressClass solves ACME HTTP-01 challenges, empty for the default
+	IngressClass string
+}
+
+var (
+	clusterIssuerGVR = schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "clusterissuers",
+	}
+	certificateGVR = schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "certificates",
+	}
+)
+
+func clusterIssuer(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "cert-manager.io/v1",
+			KIND:       "ClusterIssuer",
+			METADATA: map[string]interface{}{
+				NAME: name,
+			},
+			SPEC: spec,
+		},
+	}
+}
+
+// CreateIssuers creates the pivot ClusterIssuer, along with a self-signed CA
+// or the Secret of a user supplied one. cert-manager's webhook rejects
+// issuers until it is ready, so callers should retry.
+func (k *K8s) CreateIssuers(opts IssuerOptions) error {
+	k.list[ISSUERS] = []*unstructured.Unstructured{}
+	k.applied[ISSUERS] = nil
+	switch opts.Kind {
+	case SelfSignedIssuer, "":
+		selfSigned := clusterIssuer(selfSignedIssuer, map[string]interface{}{
+			"selfSigned": map[string]interface{}{},
+		})
+		ca := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				APIVERSION: "cert-manager.io/v1",
+				KIND:       "Certificate",
+				METADATA: map[string]interface{}{
+					NAME:      caSecret,
+					NAMESPACE: CertManager,
+				},
+				SPEC: map[string]interface{}{
+					"isCA":       true,
+					"commonName": "Pivot CA",
+					"secretName": caSecret,
+					"duration":   "87600h",
+					"privateKey": map[string]interface{}{
+						"algorithm": "ECDSA",
+						"size":      int64(256),
+					},
+					"issuerRef": map[string]interface{}{
+						NAME:    selfSignedIssuer,
+						KIND:    "ClusterIssuer",
+						"group": "cert-manager.io",
+					},
+				},
+			},
+		}
+		k.list[ISSUERS] = append(k.list[ISSUERS], selfSigned, ca)
+		if err := k.create(ISSUERS, clusterIssuerGVR, selfSigned); err != nil {
+			return err
+		}
+		if err := k.create(ISSUERS, certificateGVR, ca); err != nil {
+			return err
+		}
+		k.list[ISSUERS] = append(k.list[ISSUERS], clusterIssuer(IssuerName, map[string]interface{}{
+			"ca": map[string]interface{}{"secretName": caSecret},
+		}))
+	case CAIssuer:
+		if len(opts.CACert) == 0 || len(opts.CAKey) == 0 {
+			return errors.New("a ca issuer needs both a certificate and a key")
+		}
+		secret := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				APIVERSION: "v1",
+				KIND:       "Secret",
+				METADATA: map[string]interface{}{
+					NAME:      caSecret,
+					NAMESPACE: CertManager,
+				},
+				"type": "kubernetes.io/tls",
+				"data": map[string]interface{}{
+					"tls.crt": base64.StdEncoding.EncodeToString(opts.CACert),
+					"tls.key": base64.StdEncoding.EncodeToString(opts.CAKey),
+				},
+			},
+		}
+		// the key is not added to the list so it is never committed
+		if err := k.create(ISSUERS, secretGVR, secret); err != nil {
+			return err
+		}
+		k.list[ISSUERS] = append(k.list[ISSUERS], clusterIssuer(IssuerName, map[string]interface{}{
+			"ca": map[string]interface{}{"secretName": caSecret},
+		}))
+	case ACMEIssuer:
+		server := opts.ACMEServer
+		if server == "" {
+			server = LetsEncrypt
+		}
+		ingress := map[string]interface{}{}
+		if opts.IngressClass != "" {
+			ingress["ingressClassName"] = opts.IngressClass
+		}
+		acme := map[string]interface{}{
+			"server": server,
+			"privateKeySecretRef": map[string]interface{}{
+				NAME: acmeAccountSecret,
+			},
+			"solvers": []interface{}{
+				map[string]interface{}{
+					"http01": map[string]interface{}{
+						"ingress": ingress,
+					},
+				},
+			},
+		}
+		if opts.ACMEEmail != "" {
+			acme["email"] = opts.ACMEEmail
+		}
+		if opts.ACMESkipTLSVerify {
+			acme["skipTLSVerify"] = true
+		}
+		k.list[ISSUERS] = append(k.list[ISSUERS], clusterIssuer(IssuerName, map[string]interface{}{
+			"acme": acme,
+		}))
+	default:
+		return fmt.Errorf("unknown issuer %q, expected %s, %s or %s", opts.Kind, SelfSignedIssuer, CAIssuer, ACMEIssuer)
+	}
+	if err := k.create(ISSUERS, clusterIssuerGVR, k.list[ISSUERS][len(k.list[ISSUERS])-1]); err != nil {
+		return err
+	}
+	return k.SaveInventory(ISSUERS)
+}
+
+// IssuerCA returns the PEM certificate of the CA the pivot ClusterIssuer
+// signs with, waiting for cert-manager to issue a self-signed one. An ACME
+// issuer has none.
+func (k *K8s) IssuerCA() ([]byte, error) {
+	for tries := 0; ; tries++ {
+		ca, err := k.GetSecretValue(CertManager, caSecret, "tls.crt")
+		if err == nil && ca != "" {
+			return []byte(ca), nil
+		}
+		if tries >= 30 {
+			return nil, fmt.Errorf("ca %s/%s was not issued", CertManager, caSecret)
+		}
+		time.Sleep(waitInterval / 5)
+	}
+}
+
+// IssuedCA returns the PEM certificate of the pivot CA without waiting for
+// it, nil when there is none such as with an ACME issuer.
+func (k *K8s) IssuedCA() ([]byte, error) {
+	secret, err := k.client.Resource(secretGVR).Namespace(CertManager).Get(k.ctx, caSecret, metav1.GetOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return nil, nil
+	}
+	if err != nil {
+		k.log.Errorw("failed to get secret", "error", err, NAMESPACE, CertManager, NAME, caSecret)
+		return nil, errors.Wrap(err, "")
+	}
+	value, _, _ := unstructured.NestedString(secret.Object, "data", "tls.crt")
+	ca, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		k.log.Errorw("failed to decode secret key", "error", err, NAME, caSecret, "key", "tls.crt")
+		return nil, errors.Wrap(err, "")
+	}
+	return ca, nil
+}
+
+func (k *K8s) WriteIssuersToFile(path string) error {
+	if len(k.list[ISSUERS]) == 0 {
+		k.log.Error("no objects to write, you may need to run CreateIssuers first")
+		return errors.New("no objects to write, you may need to run CreateIssuers first")
+	}
+	return k.writeToFile(ISSUERS, path)
+}
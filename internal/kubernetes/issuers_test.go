@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCreateIssuers(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.CreateIssuers(IssuerOptions{}); err != nil {
+		t.Fatalf("CreateIssuers failed %v", err)
+	}
+	if len(k.list[ISSUERS]) != 3 {
+		t.Errorf("Expected a self-signed CA chain, got %d objects", len(k.list[ISSUERS]))
+	}
+	if _, err := client.Resource(certificateGVR).Namespace(CertManager).Get(context.TODO(), caSecret, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the CA certificate to be created %v", err)
+	}
+
+	k, client = newFakeK8s(t)
+	if err := k.CreateIssuers(IssuerOptions{Kind: CAIssuer}); err == nil {
+		t.Errorf("Expected a ca issuer without a keypair to be rejected")
+	}
+	if err := k.CreateIssuers(IssuerOptions{Kind: CAIssuer, CACert: []byte("cert"), CAKey: []byte("key")}); err != nil {
+		t.Fatalf("CreateIssuers failed %v", err)
+	}
+	for _, obj := range k.list[ISSUERS] {
+		if obj.GetKind() == "Secret" {
+			t.Errorf("Expected the CA key to never be written to the repo")
+		}
+	}
+	if _, err := client.Resource(secretGVR).Namespace(CertManager).Get(context.TODO(), caSecret, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the CA secret to be created in the cluster %v", err)
+	}
+
+	k, client = newFakeK8s(t)
+	if err := k.CreateIssuers(IssuerOptions{Kind: ACMEIssuer, ACMEServer: "https://pebble:14000/dir", ACMESkipTLSVerify: true}); err != nil {
+		t.Fatalf("CreateIssuers failed %v", err)
+	}
+	issuer, err := client.Resource(clusterIssuerGVR).Get(context.TODO(), IssuerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the pivot issuer to be created %v", err)
+	}
+	if server, _, _ := unstructured.NestedString(issuer.Object, SPEC, "acme", "server"); server != "https://pebble:14000/dir" {
+		t.Errorf("Expected the ACME directory to be configurable, got %v", server)
+	}
+}
@@ -3,24 +3,30 @@ package kubernetes
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
-	"golang.org/x/exp/maps"
 	goyaml "gopkg.in/yaml.v2"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/restmapper"
 
 	"sigs.k8s.io/kustomize/api/krusty"
 	"sigs.k8s.io/kustomize/api/resource"
@@ -39,32 +45,82 @@ const (
 	PATH       = "path"
 	SPEC       = "spec"
 	GITEA      = "gitea"
+
+	// GiteaURL is the in-cluster address of the Gitea Service
+	GiteaURL = "https://gitea.default.svc"
+	// InfraRepoURL is the in-cluster address of the infra repository
+	InfraRepoURL = GiteaURL + "/infra/infra"
+
+	// argoTLSCerts holds the CAs Argo CD trusts per repository host
+	argoTLSCerts = "argocd-tls-certs-cm"
 )
 
 type K8s struct {
 	// Kubernetes client
-	client *dynamic.DynamicClient
+	client dynamic.Interface
+	// discovery and mapper resolve kinds to resources, either may be nil
+	discovery discovery.DiscoveryInterface
+	mapper    meta.RESTMapper
+	// config is only set when built from a kubeconfig, it is needed to
+	// stream from pods
+	config *rest.Config
 	list   map[string][]*unstructured.Unstructured
-	dryRun bool
-	ctx    context.Context
-	log    *zap.SugaredLogger
+	// components deployed by Argo CD
+	components []Component
+	// generator of the init ApplicationSet and the directories it excludes
+	generator Generator
+	exclude   []string
+	// destinations the AppProject allows besides the components' namespaces
+	destinations []string
+	// rollingSync rolls the components out wave by wave
+	rollingSync bool
+	// how Gitea and Argo CD are exposed
+	expose ExposeOptions
+	// repoCA verifies the infra repo, without it Argo CD skips verification
+	repoCA []byte
+	// namespaced restricts pivot to namespaced resources, Argo CD then
+	// also manages namespaces
+	namespaced bool
+	namespaces []string
+	// problems hands the namespaces of components added while
+	// WatchProblems runs to its watcher, until problemsDone is closed
+	problems     chan map[string]string
+	problemsDone <-chan struct{}
+	// objects applied during this run, keyed by component
+	applied  map[string][]InventoryEntry
+	runID    string
+	revision string
+	versions map[string]string
+	dryRun   bool
+	ctx      context.Context
+	log      *zap.SugaredLogger
 }
 
-func NewK8s(ctx context.Context, log *zap.SugaredLogger, kubeContext string, dryRun bool) (*K8s, error) {
-	KubeContext = kubeContext
+func newK8s(ctx context.Context, log *zap.SugaredLogger) *K8s {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
-	log = log.Named("k8s").With("context", kubeContext)
 	k := &K8s{ctx: ctx, log: log}
 	k.list = make(map[string][]*unstructured.Unstructured)
+	k.applied = make(map[string][]InventoryEntry)
+	k.versions = make(map[string]string)
+	k.components = DefaultComponents()
+	k.generator = ListGenerator
+	k.expose = ExposeOptions{Kind: NoExpose}
+	k.runID = time.Now().UTC().Format("20060102-150405")
+	k.log = k.log.With("run", k.runID)
+	return k
+}
+
+func NewK8s(ctx context.Context, log *zap.SugaredLogger, getter genericclioptions.RESTClientGetter, dryRun bool) (*K8s, error) {
+	k := newK8s(ctx, log.Named("k8s").With("context", CurrentContext(getter)))
 	if dryRun {
 		k.dryRun = true
 		k.log.Info("Dry run enabled")
 		return k, nil
 	}
 
-	config, err := GetKubeConfig()
+	config, err := GetKubeConfig(getter)
 	if err != nil {
 		k.log.Errorw("failed to get k8s config", "error", err)
 		return nil, errors.Wrap(err, "")
@@ -75,10 +131,37 @@ func NewK8s(ctx context.Context, log *zap.SugaredLogger, kubeContext string, dry
 		return nil, errors.Wrap(err, "")
 	}
 	k.client = client
+	k.config = config
+	if k.discovery, err = getter.ToDiscoveryClient(); err != nil {
+		k.log.Errorw("failed to create discovery client", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
+	if k.mapper, err = getter.ToRESTMapper(); err != nil {
+		k.log.Errorw("failed to create rest mapper", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
 
 	return k, nil
 }
 
+// NewK8sForClient wraps an existing client, such as a fake or one pointed at
+// an envtest API server, so the objects pivot produces can be inspected.
+// When mapper is nil one is built from discovery; when both are nil
+// resources are guessed by pluralizing their kind.
+func NewK8sForClient(ctx context.Context, log *zap.SugaredLogger, client dynamic.Interface, disc discovery.DiscoveryInterface, mapper meta.RESTMapper) *K8s {
+	k := newK8s(ctx, log.Named("k8s"))
+	k.client = client
+	k.discovery = disc
+	k.mapper = mapper
+	if k.mapper == nil && disc != nil {
+		k.mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disc))
+	}
+	return k
+}
+
+// ApplyKustomize builds the kustomization at path and applies every resource
+// in it. The directory name is used as the component name for the inventory,
+// which is saved (and stale objects pruned) once everything has been applied.
 func (k *K8s) ApplyKustomize(path string) error {
 	kustomize := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
 	fsys := filesys.MakeFsOnDisk()
@@ -87,20 +170,19 @@ func (k *K8s) ApplyKustomize(path string) error {
 		k.log.Errorw("failed to run kustomize", "error", err)
 		return errors.Wrap(err, "")
 	}
-	if err != nil {
-		k.log.Errorw("failed to run kustomize", "error", err)
-		return errors.Wrap(err, "")
-	}
+	component := filepath.Base(path)
 	for _, r := range m.Resources() {
-		if err := k.ApplyResource(r); err != nil {
+		if err := k.ApplyResource(component, r); err != nil {
 			k.log.Errorw("failed to apply resource", "error", err)
 			return errors.Wrap(err, "")
 		}
 	}
 
-	return nil
+	return k.SaveInventory(component)
 }
 
+// CreateNamespace creates namespace, recording it in the inventory of the
+// component of the same name.
 func (k *K8s) CreateNamespace(namespace string) error {
 	ns := &unstructured.Unstructured{
 		Object: map[string]interface{}{
@@ -116,22 +198,58 @@ func (k *K8s) CreateNamespace(namespace string) error {
 		Version:  "v1",
 		Resource: "namespaces",
 	}
+	return k.create(namespace, gvr, ns)
+}
+
+// create labels obj as belonging to component, creates it and records it in
+// the component's inventory. Objects that already exist are relabeled so they
+// carry the current run ID.
+func (k *K8s) create(component string, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	// label a copy, objects in k.list are written to the repo as-is
+	obj, err := copyObject(obj)
+	if err != nil {
+		return err
+	}
+	k.labelObject(component, obj)
+	namespace := obj.GetNamespace()
+	kind := obj.GetKind()
+	k.track(component, gvr, obj)
 	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", KIND, "Namespace", NAME, namespace)
+		k.log.Infow("Dry run: Creating resource", NAMESPACE, namespace, KIND, kind, NAME, obj.GetName())
 		return nil
 	}
-	k.log.Infow("Creating resource", KIND, "Namespace", NAME, namespace)
-	_, err := k.client.Resource(gvr).Create(k.ctx, ns, metav1.CreateOptions{})
+	k.log.Infow("Creating resource", NAMESPACE, namespace, KIND, kind, NAME, obj.GetName())
+	var client dynamic.ResourceInterface = k.client.Resource(gvr)
+	if namespace != "" {
+		client = k.client.Resource(gvr).Namespace(namespace)
+	}
+	_, err = client.Create(k.ctx, obj, metav1.CreateOptions{})
 	if err != nil && strings.Contains(err.Error(), "already exists") {
-		return nil
+		k.log.Infow("Resource already exists, relabeling", NAMESPACE, namespace, KIND, kind, NAME, obj.GetName())
+		return k.relabel(client, obj)
 	} else if err != nil {
-		k.log.Errorw("failed to create resource", "error", err)
+		k.log.Errorw("failed to create resource", "error", err, NAMESPACE, namespace, KIND, kind)
 		return errors.Wrap(err, "")
 	}
 	return nil
 }
 
-func (k *K8s) ApplyResource(res *resource.Resource) error {
+// copyObject deep copies obj through JSON, objects built in code hold typed
+// slices that unstructured.DeepCopy cannot handle.
+func copyObject(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	out := &unstructured.Unstructured{}
+	if err := out.UnmarshalJSON(data); err != nil {
+		return nil, errors.Wrap(err, "")
+	}
+	return out, nil
+}
+
+// ApplyResource creates res in the cluster as part of component.
+func (k *K8s) ApplyResource(component string, res *resource.Resource) error {
 	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
 	y, err := res.AsYAML()
 	if err != nil {
@@ -144,47 +262,95 @@ func (k *K8s) ApplyResource(res *resource.Resource) error {
 		k.log.Errorw("failed to decode resource", "error", err)
 		return errors.Wrap(err, "")
 	}
-	namespace := res.GetNamespace()
-	resource := strings.ToLower(res.GetGvk().Kind)
+	return k.applyObject(component, obj)
+}
+
+// applyObject creates obj in the cluster as part of component, clearing the
+// namespace of cluster-scoped kinds.
+func (k *K8s) applyObject(component string, obj *unstructured.Unstructured) error {
+	gvr, namespaced := k.resourceFor(obj.GroupVersionKind())
+	if !namespaced {
+		if k.namespaced {
+			return fmt.Errorf("%s %s is cluster-scoped, a cluster admin has to install it", obj.GetKind(), obj.GetName())
+		}
+		obj.SetNamespace("")
+	}
+
+	return k.create(component, gvr, obj)
+}
+
+// resourceFor resolves gvk to its resource and whether it is namespaced using
+// the RESTMapper. CRDs applied earlier in the run are picked up by resetting
+// the mapper, kinds that are still unknown fall back to guessResource.
+func (k *K8s) resourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, bool) {
+	if k.mapper != nil {
+		mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			if r, ok := k.mapper.(meta.ResettableRESTMapper); ok {
+				r.Reset()
+				mapping, err = k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			}
+		}
+		if err == nil {
+			return mapping.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace
+		}
+		k.log.Debugw("failed to map resource, guessing", KIND, gvk.Kind, "error", err)
+	}
+	return guessResource(gvk), !clusterScoped[gvk.Kind]
+}
+
+// clusterScoped are the cluster-scoped kinds pivot applies, used when no
+// RESTMapper is available.
+var clusterScoped = map[string]bool{
+	"Namespace":                      true,
+	"CustomResourceDefinition":       true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"ValidatingWebhookConfiguration": true,
+	"MutatingWebhookConfiguration":   true,
+	"APIService":                     true,
+	"PriorityClass":                  true,
+	"StorageClass":                   true,
+}
+
+// guessResource guesses the resource name of gvk by pluralizing its kind.
+func guessResource(gvk schema.GroupVersionKind) schema.GroupVersionResource {
+	resource := strings.ToLower(gvk.Kind)
 	if strings.HasSuffix(resource, "y") {
 		resource = strings.TrimSuffix(resource, "y") + "ie"
 	}
 	resource = resource + "s"
 
-	gvr := schema.GroupVersionResource{
-		Group:    obj.GroupVersionKind().Group,
-		Version:  obj.GroupVersionKind().Version,
+	return schema.GroupVersionResource{
+		Group:    gvk.Group,
+		Version:  gvk.Version,
 		Resource: resource,
 	}
-	kind := obj.GetKind()
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, namespace, KIND, kind, NAME, obj.GetName())
-		return nil
-	}
-	k.log.Infow("Creating resource", NAMESPACE, namespace, KIND, kind, NAME, obj.GetName())
-	if kind == "Namespace" || kind == "CustomResourceDefinition" || kind == "ClusterRole" || kind == "ClusterRoleBinding" {
-		_, err := k.client.Resource(gvr).Create(k.ctx, obj, metav1.CreateOptions{})
-		// ignore error if already exists
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			return nil
-		} else if err != nil {
-			k.log.Errorw("failed to create resource", "error", err)
-			return errors.Wrap(err, "")
-		}
-		return nil
-	}
-	_, err = k.client.Resource(gvr).Namespace(namespace).Create(k.ctx, obj, metav1.CreateOptions{})
-	if err != nil && strings.Contains(err.Error(), "already exists") {
-		return nil
-	} else if err != nil {
-		k.log.Errorw("failed to create resource", "error", err, NAMESPACE, namespace, KIND, kind)
-		return errors.Wrap(err, "")
-	}
+}
 
-	return nil
+// SetRepoCA sets the PEM CA Argo CD verifies the infra repo with, the pivot
+// CA unless certificates come from ACME.
+func (k *K8s) SetRepoCA(ca []byte) {
+	k.repoCA = ca
 }
 
-func (k *K8s) CreateArgoInit(path, user, password string) error {
+// CreateRepoSecret creates the Argo CD repository secret of the infra repo,
+// it is never committed. The repo CA, if set, is registered for the Gitea
+// host, otherwise Argo CD does not verify Gitea's certificate.
+func (k *K8s) CreateRepoSecret(user, password string) error {
+	data := map[string]interface{}{
+		NAME:       base64.StdEncoding.EncodeToString([]byte("infra")),
+		"username": base64.StdEncoding.EncodeToString([]byte(user)),
+		"password": base64.StdEncoding.EncodeToString([]byte(password)),
+		"project":  base64.StdEncoding.EncodeToString([]byte(PLATFORM)),
+		"type":     base64.StdEncoding.EncodeToString([]byte("git")),
+		"url":      base64.StdEncoding.EncodeToString([]byte(InfraRepoURL)), // this is the internal url
+	}
+	if len(k.repoCA) == 0 {
+		data["insecure"] = base64.StdEncoding.EncodeToString([]byte("true"))
+	} else if err := k.trustRepoCA(); err != nil {
+		return err
+	}
 	repo := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			APIVERSION: "v1",
@@ -200,15 +366,7 @@ func (k *K8s) CreateArgoInit(path, user, password string) error {
 				},
 			},
 			"type": "Opaque",
-			"data": map[string]interface{}{
-				"insecure": base64.StdEncoding.EncodeToString([]byte("true")),
-				NAME:       base64.StdEncoding.EncodeToString([]byte("infra")),
-				"username": base64.StdEncoding.EncodeToString([]byte(user)),
-				"password": base64.StdEncoding.EncodeToString([]byte(password)),
-				"project":  base64.StdEncoding.EncodeToString([]byte(DEFAULT)),
-				"type":     base64.StdEncoding.EncodeToString([]byte("git")),
-				"url":      base64.StdEncoding.EncodeToString([]byte("https://gitea.default.svc/infra/infra")), // this is the internal url
-			},
+			"data": data,
 		},
 	}
 
@@ -217,17 +375,42 @@ func (k *K8s) CreateArgoInit(path, user, password string) error {
 		Version:  "v1",
 		Resource: "secrets",
 	}
+	return k.create(INIT, gvr, repo)
+}
+
+// trustRepoCA registers the repo CA for the Gitea host in the ConfigMap of
+// the Argo CD install, leaving the CAs of other hosts untouched.
+func (k *K8s) trustRepoCA() error {
+	gitea, err := url.Parse(GiteaURL)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{gitea.Hostname(): string(k.repoCA)},
+	})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
 	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, ARGOCD, KIND, "Secret", NAME, "infra-repo")
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, ARGOCD, KIND, "Secret", NAME, "infra-repo")
-		_, err := k.client.Resource(gvr).Namespace(ARGOCD).Create(k.ctx, repo, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Repo already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create repo resource", "error", err)
-			return errors.Wrap(err, "")
-		}
+		k.log.Infow("Dry run: Trusting the repo CA", NAMESPACE, ARGOCD, NAME, argoTLSCerts)
+		return nil
+	}
+	if _, err := k.client.Resource(configMapGVR).Namespace(ARGOCD).Patch(k.ctx, argoTLSCerts, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		k.log.Errorw("failed to trust the repo CA", "error", err, NAMESPACE, ARGOCD, NAME, argoTLSCerts)
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+func (k *K8s) CreateArgoInit(path, user, password string) error {
+	if err := k.CreateRepoSecret(user, password); err != nil {
+		return err
+	}
+
+	project := k.appProject()
+	k.list[ARGOCD] = []*unstructured.Unstructured{project}
+	if err := k.create(INIT, appProjectGVR, project); err != nil {
+		return err
 	}
 
 	argo := &unstructured.Unstructured{
@@ -248,12 +431,12 @@ func (k *K8s) CreateArgoInit(path, user, password string) error {
 			SPEC: map[string]interface{}{
 				"destination": map[string]interface{}{
 					NAMESPACE: ARGOCD,
-					"server":  "https://kubernetes.default.svc",
+					"server":  InClusterServer,
 				},
-				"project": DEFAULT,
+				"project": PLATFORM,
 				"source": map[string]interface{}{
 					PATH:             INIT,
-					"repoURL":        "https://gitea.default.svc/infra/infra",
+					"repoURL":        InfraRepoURL,
 					"targetRevision": "HEAD",
 				},
 				"syncPolicy": map[string]interface{}{
@@ -262,124 +445,150 @@ func (k *K8s) CreateArgoInit(path, user, password string) error {
 			},
 		},
 	}
-	k.list[ARGOCD] = []*unstructured.Unstructured{}
 	k.list[ARGOCD] = append(k.list[ARGOCD], argo)
-	gvr = schema.GroupVersionResource{
+	gvr := schema.GroupVersionResource{
 		Group:    "argoproj.io",
 		Version:  "v1alpha1",
 		Resource: "applications",
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, ARGOCD, KIND, "Application", NAME, INIT)
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, ARGOCD, KIND, "Application", NAME, INIT)
-		_, err := k.client.Resource(gvr).Namespace(ARGOCD).Create(k.ctx, argo, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Argo already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create argo resource", "error", err)
-			return errors.Wrap(err, "")
-		}
+	if err := k.create(INIT, gvr, argo); err != nil {
+		return err
 	}
 
-	apps := &unstructured.Unstructured{
-		Object: map[string]interface{}{
-			APIVERSION: "argoproj.io/v1alpha1",
-			KIND:       "ApplicationSet",
-			METADATA: map[string]interface{}{
-				NAME:      INIT,
-				NAMESPACE: ARGOCD,
-			},
-			SPEC: map[string]interface{}{
-				"goTemplate":        true,
-				"goTemplateOptions": []string{"missingkey=error"},
-				"generators": []map[string]interface{}{
-					{
-						"list": map[string]interface{}{
-							"elements": []map[string]interface{}{
-								{
-									PATH: INIT,
-								},
-								{
-									PATH: GITEA,
-								},
-								{
-									PATH: ARGOCD,
-								},
-								{
-									PATH: "cert-manager",
-								},
-								{
-									PATH: "postgres-operator",
-								},
-								{
-									PATH: "valkey-operator",
-								},
-								{
-									PATH: "gitea-operator",
-								},
-							},
-						},
-					},
-				},
-				"template": map[string]interface{}{
-					METADATA: map[string]interface{}{
-						NAME: "{{.path}}",
-						"labels": map[string]interface{}{
-							"app.kubernetes.io/managed-by": "argocd.argoproj.io",
-							"app.kubernetes.io/instance":   "{{.path}}",
-						},
-						"annotations": map[string]interface{}{
-							"argocd.argoproj.io/manifest-generate-paths": ".", // this is the path to the kustomization.yaml
-						},
-					},
-					SPEC: map[string]interface{}{
-						"destination": map[string]interface{}{
-							NAMESPACE: ARGOCD,
-							"server":  "https://kubernetes.default.svc",
-						},
-						"project": DEFAULT,
-						"source": map[string]interface{}{
-							PATH:             "{{.path}}",
-							"repoURL":        "https://gitea.default.svc/infra/infra",
-							"targetRevision": "HEAD",
-						},
-						"syncPolicy": map[string]interface{}{
-							"automated": map[string]interface{}{},
-						},
-					},
-				},
-			},
-		},
+	apps, err := k.applicationSet()
+	if err != nil {
+		return err
+	}
+	if waves := k.waves(); !k.rollingSync && len(waves) > 1 {
+		k.log.Warnw("Waves are not enforced without a RollingSync, the Applications sync in parallel", "waves", len(waves))
 	}
 	k.list[ARGOCD] = append(k.list[ARGOCD], apps)
-	return nil
+	if k.namespaced {
+		for _, obj := range namespacedArgo(k.managedNamespaces()) {
+			k.list[ARGOCD] = append(k.list[ARGOCD], obj)
+			gvr, _ := k.resourceFor(obj.GroupVersionKind())
+			if err := k.create(INIT, gvr, obj); err != nil {
+				return err
+			}
+		}
+	}
+	if k.expose.ArgoHost != "" && k.expose.Kind != NoExpose {
+		cert := argoCertificate(k.expose.ArgoHost)
+		k.list[ARGOCD] = append(k.list[ARGOCD], cert)
+		if err := k.create(INIT, certificateGVR, cert); err != nil {
+			return err
+		}
+		if err := k.exposeService(INIT, ARGOCD, ARGOCD, argoServer, k.expose.ArgoHost); err != nil {
+			return err
+		}
+	}
+	return k.SaveInventory(INIT)
 }
 
-func (k *K8s) GetPivotPassword() (string, error) {
-	secret, err := k.client.Resource(schema.GroupVersionResource{
-		Group:    "",
-		Version:  "v1",
-		Resource: "secrets",
-	}).Namespace(DEFAULT).Get(k.ctx, "pivot-password", metav1.GetOptions{})
+// GetUserPassword returns the password of the Gitea user, stored by
+// CreateGitea in the <user>-password Secret of namespace.
+func (k *K8s) GetUserPassword(namespace, user string) (string, error) {
+	return k.GetSecretValue(namespace, user+"-password", "password")
+}
+
+// GetArgoAdminPassword returns the initial password of the Argo CD admin
+// user, Argo CD keeps it until the Secret is deleted.
+func (k *K8s) GetArgoAdminPassword() (string, error) {
+	return k.GetSecretValue(ARGOCD, "argocd-initial-admin-secret", "password")
+}
+
+// GetSecretValue returns the decoded value of key in the Secret
+// namespace/name.
+func (k *K8s) GetSecretValue(namespace, name, key string) (string, error) {
+	secret, err := k.client.Resource(secretGVR).Namespace(namespace).Get(k.ctx, name, metav1.GetOptions{})
 	if err != nil {
-		k.log.Errorw("failed to get secret", "error", err)
+		k.log.Errorw("failed to get secret", "error", err, NAMESPACE, namespace, NAME, name)
 		return "", errors.Wrap(err, "")
 	}
-	pass, _, err := unstructured.NestedString(secret.Object, "data", "password")
+	value, _, err := unstructured.NestedString(secret.Object, "data", key)
 	if err != nil {
-		k.log.Errorw("failed to get password", "error", err)
+		k.log.Errorw("failed to get secret key", "error", err, NAME, name, "key", key)
 		return "", errors.Wrap(err, "")
 	}
-	passb64, err := base64.StdEncoding.DecodeString(pass)
+	decoded, err := base64.StdEncoding.DecodeString(value)
 	if err != nil {
-		k.log.Errorw("failed to decode password", "error", err)
+		k.log.Errorw("failed to decode secret key", "error", err, NAME, name, "key", key)
 		return "", errors.Wrap(err, "")
 	}
-	return string(passb64), nil
+	return string(decoded), nil
 }
 
-func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) error {
+// UpdateRepoCredentials replaces the credentials Argo CD uses to pull the
+// infra repo.
+func (k *K8s) UpdateRepoCredentials(user, password string) error {
+	k.log.Infow("Updating repository credentials", NAMESPACE, ARGOCD, NAME, "infra-repo", "user", user)
+	return k.SetSecretData(ARGOCD, "infra-repo", map[string]string{
+		"username": user,
+		"password": password,
+	})
+}
+
+// SetSecretData sets the given keys of the Secret namespace/name, leaving
+// its other keys untouched.
+func (k *K8s) SetSecretData(namespace, name string, data map[string]string) error {
+	encoded := map[string]interface{}{}
+	for key, value := range data {
+		encoded[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	patch, err := json.Marshal(map[string]interface{}{"data": encoded})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if k.dryRun {
+		k.log.Infow("Dry run: Updating secret", NAMESPACE, namespace, NAME, name)
+		return nil
+	}
+	if _, err := k.client.Resource(secretGVR).Namespace(namespace).Patch(k.ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		k.log.Errorw("failed to update secret", "error", err, NAMESPACE, namespace, NAME, name)
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
+
+// GiteaOptions configures the Gitea instance created by CreateGitea.
+type GiteaOptions struct {
+	// User is the admin of the infra org and Password its password
+	User     string
+	Password string
+	// DeployPassword is the password of the read-only DeployUser Argo CD
+	// creates its access token as, no deploy user is created when empty
+	DeployPassword string
+	// Domain is the ingress host of Gitea
+	Domain string
+	Valkey bool
+	// Issuer is the ClusterIssuer of Gitea's certificates, IssuerName when
+	// empty
+	Issuer string
+	// Actions enables Gitea Actions
+	Actions bool
+	// Replicas of Gitea and of its Postgres cluster, the operator's
+	// defaults when zero
+	Replicas         int64
+	PostgresReplicas int64
+	// Requests are the default container requests of Gitea's namespace,
+	// none when empty. They apply to every container there that sets none,
+	// not just Gitea's, so callers should only set them on request.
+	// Namespace admins cannot create LimitRanges, so they are not set
+	// namespaced.
+	Requests map[string]string
+}
+
+// DeployUser is the read-only Gitea user Argo CD pulls the infra repo as.
+const DeployUser = "argocd"
+
+// DeployTokenScopes are the scopes of the access token issued to DeployUser.
+var DeployTokenScopes = []string{"read:repository"}
+
+func (k *K8s) CreateGitea(path string, opts GiteaOptions) error {
+	issuer := opts.Issuer
+	if issuer == "" {
+		issuer = IssuerName
+	}
 	gitea := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			APIVERSION: "hyperspike.io/v1",
@@ -390,14 +599,34 @@ func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) erro
 			},
 			SPEC: map[string]interface{}{
 				"tls":        true,
-				"valkey":     valkey,
-				"certIssuer": "selfsigned",
-				"ingress": map[string]interface{}{
-					"host": domain,
-				},
+				"valkey":     opts.Valkey,
+				"actions":    opts.Actions,
+				"certIssuer": issuer,
 			},
 		},
 	}
+	// an exposed Gitea gets its Ingress or HTTPRoute from pivot, the
+	// operator's would duplicate it
+	if k.expose.Kind == NoExpose {
+		if err := unstructured.SetNestedMap(gitea.Object, map[string]interface{}{
+			"host": opts.Domain,
+			"annotations": map[string]interface{}{
+				"cert-manager.io/cluster-issuer": issuer,
+			},
+		}, SPEC, "ingress"); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if opts.Replicas > 0 {
+		if err := unstructured.SetNestedField(gitea.Object, opts.Replicas, SPEC, "replicas"); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+	if opts.PostgresReplicas > 0 {
+		if err := unstructured.SetNestedField(gitea.Object, opts.PostgresReplicas, SPEC, "postgres", "replicas"); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
 	k.list[GITEA] = []*unstructured.Unstructured{}
 	k.list[GITEA] = append(k.list[GITEA], gitea)
 	gvr := schema.GroupVersionResource{
@@ -405,90 +634,40 @@ func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) erro
 		Version:  "v1",
 		Resource: GITEA,
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, DEFAULT, KIND, "Gitea", NAME, GITEA)
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, DEFAULT, KIND, "Gitea", NAME, GITEA)
-		_, err := k.client.Resource(gvr).Namespace(DEFAULT).Create(k.ctx, gitea, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Gitea already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create gitea resource", "error", err)
-			return errors.Wrap(err, "")
-		}
-	}
-
-	base64pass := base64.StdEncoding.EncodeToString([]byte(password))
-
-	passwordSecret := &unstructured.Unstructured{
-		Object: map[string]interface{}{
-			APIVERSION: "v1",
-			KIND:       "Secret",
-			METADATA: map[string]interface{}{
-				NAME:      user + "-password",
-				NAMESPACE: DEFAULT,
-			},
-			"type": "Opaque",
-			"data": map[string]interface{}{
-				"password": base64pass,
-			},
-		},
-	}
-	gvr = schema.GroupVersionResource{
-		Group:    "",
-		Version:  "v1",
-		Resource: "secrets",
+	if err := k.create(GITEA, gvr, gitea); err != nil {
+		return err
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, DEFAULT, KIND, "Secret", NAME, user+"-password")
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, DEFAULT, KIND, "Secret", NAME, user+"-password")
-		_, err := k.client.Resource(gvr).Namespace(DEFAULT).Create(k.ctx, passwordSecret, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Password already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create password resource", "error", err)
-			return errors.Wrap(err, "")
+	if len(opts.Requests) > 0 && !k.namespaced {
+		lr := defaultRequests(DEFAULT, opts.Requests)
+		k.list[GITEA] = append(k.list[GITEA], lr)
+		if err := k.create(GITEA, limitRangeGVR, lr); err != nil {
+			return err
 		}
 	}
 
-	giteaUser := &unstructured.Unstructured{
-		Object: map[string]interface{}{
-			APIVERSION: "hyperspike.io/v1",
-			KIND:       "User",
-			METADATA: map[string]interface{}{
-				NAME:      user,
-				NAMESPACE: DEFAULT,
-			},
-			SPEC: map[string]interface{}{
-				"email": fmt.Sprintf("%s@%s", user, domain),
-				"password": map[string]interface{}{
-					NAME:  user + "-password",
-					"key": "password",
-				},
-				"instance": map[string]interface{}{
-					NAME: GITEA,
-				},
-			},
-		},
+	if err := k.createGiteaUser(opts.User, opts.Password, opts.Domain); err != nil {
+		return err
 	}
-	k.list[GITEA] = append(k.list[GITEA], giteaUser)
-	gvr = schema.GroupVersionResource{
-		Group:    "hyperspike.io",
-		Version:  "v1",
-		Resource: "users",
+	teams := []map[string]interface{}{
+		{
+			NAME:              "admin",
+			"permission":      "admin",
+			"includeAllRepos": true,
+			"createOrgRepo":   true,
+			"members":         []string{opts.User},
+		},
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, DEFAULT, KIND, "User", NAME, user)
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, DEFAULT, KIND, "User", NAME, user)
-		_, err := k.client.Resource(gvr).Namespace(DEFAULT).Create(k.ctx, giteaUser, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("User already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create user resource", "error", err)
-			return errors.Wrap(err, "")
+	if opts.DeployPassword != "" {
+		if err := k.createGiteaUser(DeployUser, opts.DeployPassword, opts.Domain); err != nil {
+			return err
 		}
+		teams = append(teams, map[string]interface{}{
+			NAME:              "readers",
+			"permission":      "read",
+			"includeAllRepos": true,
+			"createOrgRepo":   false,
+			"members":         []string{DeployUser},
+		})
 	}
 
 	org := &unstructured.Unstructured{
@@ -504,15 +683,7 @@ func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) erro
 				"instance": map[string]interface{}{
 					NAME: GITEA,
 				},
-				"teams": []map[string]interface{}{
-					{
-						NAME:              "admin",
-						"permission":      "admin",
-						"includeAllRepos": true,
-						"createOrgRepo":   true,
-						"members":         []string{user},
-					},
-				},
+				"teams": teams,
 			},
 		},
 	}
@@ -522,17 +693,8 @@ func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) erro
 		Version:  "v1",
 		Resource: "orgs",
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, DEFAULT, KIND, "Org", NAME, "infra")
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, DEFAULT, KIND, "Org", NAME, "infra")
-		_, err := k.client.Resource(gvr).Namespace(DEFAULT).Create(k.ctx, org, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Org already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create org resource", "error", err)
-			return errors.Wrap(err, "")
-		}
+	if err := k.create(GITEA, gvr, org); err != nil {
+		return err
 	}
 
 	repo := &unstructured.Unstructured{
@@ -557,19 +719,84 @@ func (k *K8s) CreateGitea(path, user, password, domain string, valkey bool) erro
 		Version:  "v1",
 		Resource: "repoes",
 	}
-	if k.dryRun {
-		k.log.Infow("Dry run: Creating resource", NAMESPACE, DEFAULT, KIND, "Repo", NAME, "infra")
-	} else {
-		k.log.Infow("Creating resource", NAMESPACE, DEFAULT, KIND, "Repo", NAME, "infra")
-		_, err := k.client.Resource(gvr).Namespace(DEFAULT).Create(k.ctx, repo, metav1.CreateOptions{})
-		if err != nil && strings.Contains(err.Error(), "already exists") {
-			k.log.Infow("Repo already exists, ignoring")
-		} else if err != nil {
-			k.log.Errorw("failed to create repo resource", "error", err)
-			return errors.Wrap(err, "")
-		}
+	if err := k.create(GITEA, gvr, repo); err != nil {
+		return err
 	}
-	return nil
+	if err := k.createGateway(opts.Domain); err != nil {
+		return err
+	}
+	if err := k.exposeService(GITEA, GITEA, DEFAULT, GITEA, opts.Domain); err != nil {
+		return err
+	}
+	return k.SaveInventory(GITEA)
+}
+
+// CreatePasswordSecret creates the <user>-password Secret the gitea-operator
+// reads a user's password from, or sets the password of an existing one. It
+// is never committed.
+func (k *K8s) CreatePasswordSecret(user, password string) error {
+	base64pass := base64.StdEncoding.EncodeToString([]byte(password))
+
+	passwordSecret := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Secret",
+			METADATA: map[string]interface{}{
+				NAME:      user + "-password",
+				NAMESPACE: DEFAULT,
+			},
+			"type": "Opaque",
+			"data": map[string]interface{}{
+				"password": base64pass,
+			},
+		},
+	}
+	gvr := schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "secrets",
+	}
+	if err := k.create(GITEA, gvr, passwordSecret); err != nil {
+		return err
+	}
+	// an existing Secret, e.g. on restore, is only relabeled by create
+	return k.SetSecretData(DEFAULT, user+"-password", map[string]string{"password": password})
+}
+
+// createGiteaUser creates a Gitea User along with the Secret holding its
+// password.
+func (k *K8s) createGiteaUser(user, password, domain string) error {
+	if err := k.CreatePasswordSecret(user, password); err != nil {
+		return err
+	}
+
+	giteaUser := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "hyperspike.io/v1",
+			KIND:       "User",
+			METADATA: map[string]interface{}{
+				NAME:      user,
+				NAMESPACE: DEFAULT,
+			},
+			SPEC: map[string]interface{}{
+				"email": fmt.Sprintf("%s@%s", user, domain),
+				"password": map[string]interface{}{
+					NAME:  user + "-password",
+					"key": "password",
+				},
+				"instance": map[string]interface{}{
+					NAME: GITEA,
+				},
+			},
+		},
+	}
+	k.list[GITEA] = append(k.list[GITEA], giteaUser)
+	gvr := schema.GroupVersionResource{
+		Group:    "hyperspike.io",
+		Version:  "v1",
+		Resource: "users",
+	}
+	return k.create(GITEA, gvr, giteaUser)
 }
 
 func (k *K8s) WriteGiteaToFile(path string) error {
@@ -627,36 +854,32 @@ func (k *K8s) writeToFile(list, path string) error {
 	return nil
 }
 
-var KubeContext string = ""
-
-func includes(list []string, item string) bool {
-	for _, i := range list {
-		if i == item {
-			return true
-		}
-	}
-	return false
-}
-
-func fetchKubeConfig() (*clientcmdapi.Config, error) {
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" {
-		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+// CurrentContext returns the kubeconfig context getter resolves to, or an
+// empty string when running in-cluster.
+func CurrentContext(getter genericclioptions.RESTClientGetter) string {
+	if f, ok := getter.(*genericclioptions.ConfigFlags); ok && f.Context != nil && *f.Context != "" {
+		return *f.Context
 	}
-	cfg, err := clientcmd.LoadFromFile(kubeconfig)
+	raw, err := getter.ToRawKubeConfigLoader().RawConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		return ""
 	}
-	if KubeContext != "" {
-		if includes(maps.Keys(cfg.Contexts), KubeContext) {
-			cfg.CurrentContext = KubeContext
-		} else {
-			return nil, fmt.Errorf("context %s not found in kubeconfig", KubeContext)
-		}
+	return raw.CurrentContext
+}
+
+// InCluster reports whether pivot is running inside a pod without a
+// kubeconfig, in which case the pod's ServiceAccount is used.
+func InCluster(getter genericclioptions.RESTClientGetter) bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
 	}
-	return cfg, nil
+	raw, err := getter.ToRawKubeConfigLoader().RawConfig()
+	return err == nil && len(raw.Contexts) == 0
 }
 
-func GetKubeConfig() (*rest.Config, error) {
-	return clientcmd.BuildConfigFromKubeconfigGetter("", fetchKubeConfig)
+// GetKubeConfig resolves the client configuration from getter, following
+// the same loading rules as kubectl: --kubeconfig, merged KUBECONFIG lists,
+// ~/.kube/config and finally the in-cluster ServiceAccount.
+func GetKubeConfig(getter genericclioptions.RESTClientGetter) (*rest.Config, error) {
+	return getter.ToRESTConfig()
 }
@@ -1,35 +1,23 @@
 package kubernetes
 
 import (
+	"context"
+	"encoding/base64"
 	"os"
+	"path/filepath"
 	"testing"
-)
 
-func TestKubeContext(t *testing.T) {
-	KubeContext = "does-not-exist"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
 
-	_, err := GetKubeConfig()
-	if err == nil {
-		t.Errorf("Expected error when kube context does not exist")
-	}
-	_ = os.Setenv("KUBECONFIG", "testdata/kubeconfig")
-	KubeContext = DEFAULT
-	if err := os.Mkdir("testdata", 0755); err != nil {
-		t.Errorf("Error creating testdata directory %v", err)
-	}
-	// Create a kubeconfig file in the testdata directory
-	file, err := os.Create("testdata/kubeconfig")
-	if err != nil {
-		t.Errorf("Error creating kubeconfig file %v", err)
-	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			t.Errorf("Error closing kubeconfig file %v", err)
-		}
-		_ = os.Remove("testdata/kubeconfig")
-		_ = os.Remove("testdata")
-	}()
-	if _, err := file.WriteString(`apiVersion: v1
+const testKubeConfig = `apiVersion: v1
 clusters:
 - cluster:
     server: https://localhost:6443
@@ -52,22 +40,333 @@ preferences: {}
 users:
 - name: default
 - name: minikube
-`); err != nil {
-		t.Errorf("Error writing to kubeconfig file %v", err)
+`
+
+const testKindConfig = `apiVersion: v1
+clusters:
+- cluster:
+    server: https://kind:6443
+  name: kind
+contexts:
+- context:
+    cluster: kind
+    user: kind
+  name: kind
+kind: Config
+preferences: {}
+users:
+- name: kind
+  user:
+    token: kind-token
+`
+
+func configFlags(kubeconfig, context string) *genericclioptions.ConfigFlags {
+	f := genericclioptions.NewConfigFlags(false)
+	f.KubeConfig = &kubeconfig
+	f.Context = &context
+	return f
+}
+
+func writeKubeConfig(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing kubeconfig file %v", err)
+	}
+	return path
+}
+
+func TestKubeContext(t *testing.T) {
+	dir := t.TempDir()
+	kubeconfig := writeKubeConfig(t, dir, "kubeconfig", testKubeConfig)
+
+	_, err := GetKubeConfig(configFlags(kubeconfig, "does-not-exist"))
+	if err == nil {
+		t.Errorf("Expected error when kube context does not exist")
 	}
-	KubeContext = DEFAULT
 
-	_, err = GetKubeConfig()
+	cfg, err := GetKubeConfig(configFlags(kubeconfig, DEFAULT))
 	if err != nil {
 		t.Errorf("Error getting [default] kubeconfig %v", err)
+	} else if cfg.Host != "https://localhost:6443" {
+		t.Errorf("Expected host to be https://localhost:6443, got %v", cfg.Host)
 	}
-	KubeContext = "minikube"
 
-	cfg, err := GetKubeConfig()
+	cfg, err = GetKubeConfig(configFlags(kubeconfig, "minikube"))
 	if err != nil {
 		t.Errorf("Error getting [minikube] kubeconfig %v", err)
-	}
-	if cfg.Host != "https://minikube:8443" {
+	} else if cfg.Host != "https://minikube:8443" {
 		t.Errorf("Expected host to be https://minikube:8443, got %v", cfg.Host)
 	}
+	if name := CurrentContext(configFlags(kubeconfig, "")); name != DEFAULT {
+		t.Errorf("Expected current context to be default, got %v", name)
+	}
+}
+
+func TestMergedKubeConfig(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKubeConfig(t, dir, "first", testKubeConfig)
+	second := writeKubeConfig(t, dir, "second", testKindConfig)
+	t.Setenv("KUBECONFIG", first+string(os.PathListSeparator)+second)
+
+	cfg, err := GetKubeConfig(configFlags("", "kind"))
+	if err != nil {
+		t.Fatalf("Error getting [kind] kubeconfig from merged KUBECONFIG %v", err)
+	}
+	if cfg.Host != "https://kind:6443" {
+		t.Errorf("Expected host to be https://kind:6443, got %v", cfg.Host)
+	}
+	if cfg.BearerToken != "kind-token" {
+		t.Errorf("Expected token from the second kubeconfig, got %v", cfg.BearerToken)
+	}
+
+	flags := configFlags("", "minikube")
+	impersonate := "admin"
+	token := "override"
+	insecure := true
+	flags.Impersonate = &impersonate
+	flags.BearerToken = &token
+	flags.Insecure = &insecure
+	cfg, err = GetKubeConfig(flags)
+	if err != nil {
+		t.Fatalf("Error getting [minikube] kubeconfig from merged KUBECONFIG %v", err)
+	}
+	if cfg.Impersonate.UserName != "admin" || cfg.BearerToken != "override" || !cfg.Insecure {
+		t.Errorf("Expected auth flags to override the kubeconfig, got %+v", cfg)
+	}
+}
+
+var fakeListKinds = map[schema.GroupVersionResource]string{
+	{Version: "v1", Resource: "namespaces"}:                                               "NamespaceList",
+	{Version: "v1", Resource: "secrets"}:                                                  "SecretList",
+	{Version: "v1", Resource: "configmaps"}:                                               "ConfigMapList",
+	{Version: "v1", Resource: "services"}:                                                 "ServiceList",
+	{Group: "apps", Version: "v1", Resource: "deployments"}:                               "DeploymentList",
+	{Version: "v1", Resource: "serviceaccounts"}:                                          "ServiceAccountList",
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}:                "RoleList",
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}:         "RoleBindingList",
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}:         "ClusterRoleList",
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}:  "ClusterRoleBindingList",
+	{Group: "batch", Version: "v1", Resource: "cronjobs"}:                                 "CronJobList",
+	{Version: "v1", Resource: "events"}:                                                   "EventList",
+	{Version: "v1", Resource: "pods"}:                                                     "PodList",
+	{Version: "v1", Resource: "nodes"}:                                                    "NodeList",
+	{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}:                  "StorageClassList",
+	{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}: "CustomResourceDefinitionList",
+	{Group: "hyperspike.io", Version: "v1", Resource: GITEA}:                              "GiteaList",
+	{Group: "acid.zalan.do", Version: "v1", Resource: "postgresqls"}:                      "postgresqlList",
+	{Group: "hyperspike.io", Version: "v1", Resource: "users"}:                            "UserList",
+	{Group: "hyperspike.io", Version: "v1", Resource: "orgs"}:                             "OrgList",
+	{Group: "hyperspike.io", Version: "v1", Resource: "repoes"}:                           "RepoList",
+	{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}:                 "ApplicationList",
+	{Group: "argoproj.io", Version: "v1alpha1", Resource: "appprojects"}:                  "AppProjectList",
+	{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}:                 "ClusterIssuerList",
+	{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}:                   "CertificateList",
+	{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}:                    "IngressList",
+	{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}:             "GatewayList",
+	{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}:           "HTTPRouteList",
+}
+
+// newFakeK8s returns a K8s backed by a fake dynamic client and a RESTMapper
+// that knows the core kinds used in tests.
+func newFakeK8s(t *testing.T, objects ...runtime.Object) (*K8s, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...)
+	return NewK8sForClient(context.TODO(), zap.NewNop().Sugar(), client, nil, fakeMapper()), client
+}
+
+// fakeMapper returns a RESTMapper that knows the core kinds used in tests.
+func fakeMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	return mapper
+}
+
+func TestCreateGiteaWithFakeClient(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.CreateGitea("", GiteaOptions{
+		User:           "alice",
+		Password:       "secret",
+		DeployPassword: "deploy",
+		Domain:         "git.example.com",
+		Valkey:         true,
+	}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+
+	gvr := giteaGVR
+	gitea, err := client.Resource(gvr).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected gitea to be created %v", err)
+	}
+	if host, _, _ := unstructured.NestedString(gitea.Object, SPEC, "ingress", "host"); host != "git.example.com" {
+		t.Errorf("Expected ingress host git.example.com, got %v", host)
+	}
+	if gitea.GetLabels()[PartOfLabel] != PIVOT || gitea.GetLabels()[RunIDLabel] != k.RunID() {
+		t.Errorf("Expected pivot labels on gitea, got %v", gitea.GetLabels())
+	}
+
+	secret, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "secrets"}).
+		Namespace(DEFAULT).Get(context.TODO(), "alice-password", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected password secret to be created %v", err)
+	}
+	if pass, _, _ := unstructured.NestedString(secret.Object, "data", "password"); pass != base64.StdEncoding.EncodeToString([]byte("secret")) {
+		t.Errorf("Unexpected password %v", pass)
+	}
+
+	inv, err := k.GetInventory(GITEA)
+	if err != nil || inv == nil {
+		t.Fatalf("Expected gitea inventory %v", err)
+	}
+	if len(inv.Entries) != 7 {
+		t.Errorf("Expected 7 inventory entries, got %d", len(inv.Entries))
+	}
+	if deploy, err := k.GetSecretValue(DEFAULT, DeployUser+"-password", "password"); err != nil || deploy != "deploy" {
+		t.Errorf("Expected deploy user password secret, got %v %v", deploy, err)
+	}
+}
+
+func TestApplyKustomizeWithFakeClient(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "demo")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"kustomization.yaml": "namespace: demo\nresources:\n- namespace.yaml\n- config.yaml\n",
+		"namespace.yaml":     "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: demo\n",
+		"config.yaml":        "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: demo\ndata:\n  key: value\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k, client := newFakeK8s(t)
+	if err := k.ApplyKustomize(dir); err != nil {
+		t.Fatalf("ApplyKustomize failed %v", err)
+	}
+	if _, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}).
+		Get(context.TODO(), "demo", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected cluster-scoped namespace to be created %v", err)
+	}
+	cm, err := client.Resource(configMapGVR).Namespace("demo").Get(context.TODO(), "demo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected configmap to be created %v", err)
+	}
+	if cm.GetLabels()[ComponentLabel] != "demo" {
+		t.Errorf("Expected component label demo, got %v", cm.GetLabels())
+	}
+}
+
+func TestSetSecretData(t *testing.T) {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Secret",
+		METADATA:   map[string]interface{}{NAME: "infra-repo", NAMESPACE: ARGOCD},
+		"data": map[string]interface{}{
+			"url":      base64.StdEncoding.EncodeToString([]byte(InfraRepoURL)),
+			"password": base64.StdEncoding.EncodeToString([]byte("old")),
+		},
+	}}
+	k, _ := newFakeK8s(t, secret)
+	if err := k.SetSecretData(ARGOCD, "infra-repo", map[string]string{"password": "new"}); err != nil {
+		t.Fatalf("SetSecretData failed %v", err)
+	}
+	if pass, err := k.GetSecretValue(ARGOCD, "infra-repo", "password"); err != nil || pass != "new" {
+		t.Errorf("Expected the password to be replaced, got %v %v", pass, err)
+	}
+	if url, err := k.GetSecretValue(ARGOCD, "infra-repo", "url"); err != nil || url != InfraRepoURL {
+		t.Errorf("Expected other keys to be left untouched, got %v %v", url, err)
+	}
+	if err := k.SetSecretData(ARGOCD, "missing", map[string]string{"password": "new"}); err == nil {
+		t.Errorf("Expected patching a missing secret to fail")
+	}
+
+	k.dryRun = true
+	if err := k.SetSecretData(ARGOCD, "infra-repo", map[string]string{"password": "dry"}); err != nil {
+		t.Fatalf("SetSecretData failed %v", err)
+	}
+	if pass, _ := k.GetSecretValue(ARGOCD, "infra-repo", "password"); pass != "new" {
+		t.Errorf("Expected a dry run to leave the secret untouched, got %v", pass)
+	}
+}
+
+func TestCreateRepoSecretTrustsCA(t *testing.T) {
+	certs := &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "ConfigMap",
+		METADATA:   map[string]interface{}{NAME: argoTLSCerts, NAMESPACE: ARGOCD},
+		"data":     map[string]interface{}{"github.com": "other"},
+	}}
+	k, client := newFakeK8s(t, certs)
+	if err := k.CreateRepoSecret(DeployUser, "token"); err != nil {
+		t.Fatalf("CreateRepoSecret failed %v", err)
+	}
+	if insecure, _ := k.GetSecretValue(ARGOCD, "infra-repo", "insecure"); insecure != "true" {
+		t.Errorf("Expected an unknown CA to skip verification, got %v", insecure)
+	}
+
+	k, client = newFakeK8s(t, certs)
+	k.SetRepoCA([]byte("pivot-ca"))
+	if err := k.CreateRepoSecret(DeployUser, "token"); err != nil {
+		t.Fatalf("CreateRepoSecret failed %v", err)
+	}
+	if insecure, _ := k.GetSecretValue(ARGOCD, "infra-repo", "insecure"); insecure != "" {
+		t.Errorf("Expected the repo to be verified, got insecure %v", insecure)
+	}
+	cm, err := client.Resource(configMapGVR).Namespace(ARGOCD).Get(context.TODO(), argoTLSCerts, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the Argo CD certificates %v", err)
+	}
+	data, _, _ := unstructured.NestedStringMap(cm.Object, "data")
+	if data["gitea.default.svc"] != "pivot-ca" || data["github.com"] != "other" {
+		t.Errorf("Expected the CA to be trusted for the Gitea host only, got %v", data)
+	}
+
+	if err := k.UpdateRepoCredentials(DeployUser, "rotated"); err != nil {
+		t.Fatalf("UpdateRepoCredentials failed %v", err)
+	}
+	if pass, err := k.GetSecretValue(ARGOCD, "infra-repo", "password"); err != nil || pass != "rotated" {
+		t.Errorf("Expected the repo to be pulled with the new token, got %v %v", pass, err)
+	}
+}
+
+func TestGetArgoAdminPassword(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if _, err := k.GetArgoAdminPassword(); err == nil {
+		t.Errorf("Expected a deleted initial admin secret to fail")
+	}
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Secret",
+		METADATA:   map[string]interface{}{NAME: "argocd-initial-admin-secret", NAMESPACE: ARGOCD},
+		"data": map[string]interface{}{
+			"password": base64.StdEncoding.EncodeToString([]byte("admin-pass")),
+		},
+	}}
+	k, _ = newFakeK8s(t, secret)
+	if pass, err := k.GetArgoAdminPassword(); err != nil || pass != "admin-pass" {
+		t.Errorf("Expected the initial admin password, got %v %v", pass, err)
+	}
+}
+
+func TestCreatePasswordSecretReplacesPassword(t *testing.T) {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Secret",
+		METADATA:   map[string]interface{}{NAME: "alice-password", NAMESPACE: DEFAULT},
+		"data": map[string]interface{}{
+			"password": base64.StdEncoding.EncodeToString([]byte("before-restore")),
+		},
+	}}
+	k, _ := newFakeK8s(t, secret)
+	if err := k.CreatePasswordSecret("alice", "restored"); err != nil {
+		t.Fatalf("CreatePasswordSecret failed %v", err)
+	}
+	if pass, err := k.GetUserPassword(DEFAULT, "alice"); err != nil || pass != "restored" {
+		t.Errorf("Expected the existing secret to get the new password, got %v %v", pass, err)
+	}
 }
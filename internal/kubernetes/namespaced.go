@@ -0,0 +1,318 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// argoManager is the Role granting Argo CD access to a namespace it manages
+// when installed namespaced.
+const argoManager = "argocd-manager"
+
+// clusterComponents install cluster-scoped resources, when namespaced a
+// cluster admin installs them instead.
+var clusterComponents = map[string]bool{
+	"cert-manager":      true,
+	ISSUERS:             true,
+	"postgres-operator": true,
+	"valkey-operator":   true,
+	"gitea-operator":    true,
+}
+
+// Prerequisite is something a cluster admin has to install or grant before
+// pivot can run namespaced.
+type Prerequisite struct {
+	// What is missing, e.g. a CRD
+	What string
+	// How to provide it
+	How string
+}
+
+func (p Prerequisite) String() string {
+	return p.What + ": " + p.How
+}
+
+// crdPrerequisite is a CRD the namespaced mode relies on.
+type crdPrerequisite struct {
+	gv       schema.GroupVersion
+	resource string
+	how      string
+}
+
+var (
+	argoCRDs = "install the Argo CD CRDs (manifests/crds in argo-cd)"
+	crds     = []crdPrerequisite{
+		{schema.GroupVersion{Group: "argoproj.io", Version: "v1alpha1"}, "applications", argoCRDs},
+		{schema.GroupVersion{Group: "argoproj.io", Version: "v1alpha1"}, "applicationsets", argoCRDs},
+		{schema.GroupVersion{Group: "argoproj.io", Version: "v1alpha1"}, "appprojects", argoCRDs},
+		{schema.GroupVersion{Group: "cert-manager.io", Version: "v1"}, "certificates", "install cert-manager"},
+		{schema.GroupVersion{Group: "cert-manager.io", Version: "v1"}, "clusterissuers", "install cert-manager"},
+		{schema.GroupVersion{Group: "acid.zalan.do", Version: "v1"}, "postgresqls", "install the postgres-operator"},
+		{schema.GroupVersion{Group: "hyperspike.io", Version: "v1"}, GITEA, "install the gitea-operator"},
+		{schema.GroupVersion{Group: "hyperspike.io", Version: "v1"}, "users", "install the gitea-operator"},
+		{schema.GroupVersion{Group: "hyperspike.io", Version: "v1"}, "orgs", "install the gitea-operator"},
+		{schema.GroupVersion{Group: "hyperspike.io", Version: "v1"}, "repoes", "install the gitea-operator"},
+	}
+	valkeyCRD = crdPrerequisite{schema.GroupVersion{Group: "hyperspike.io", Version: "v1"}, "valkeys", "install the valkey-operator"}
+)
+
+// namespacedAccess are the permissions pivot needs in each namespace it
+// manages.
+var namespacedAccess = []schema.GroupVersionResource{
+	secretGVR,
+	configMapGVR,
+	deploymentGVR,
+	serviceAccountGVR,
+	roleGVR,
+	roleBindingGVR,
+}
+
+var (
+	namespaceGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "namespaces",
+	}
+	selfSubjectAccessReviewGVR = schema.GroupVersionResource{
+		Group:    "authorization.k8s.io",
+		Version:  "v1",
+		Resource: "selfsubjectaccessreviews",
+	}
+)
+
+// SetNamespaced restricts pivot to namespaced resources: cert-manager, the
+// issuers and the operators are left to a cluster admin and Argo CD only
+// manages argocd, the namespaces of the components and destinations, and
+// namespaces.
+func (k *K8s) SetNamespaced(namespaces []string) {
+	k.namespaced = true
+	k.namespaces = namespaces
+	components := []Component{}
+	for _, c := range k.components {
+		if !clusterComponents[c.Path] {
+			components = append(components, c)
+		}
+	}
+	k.components = components
+}
+
+// Namespaced reports whether pivot runs without cluster-scoped access.
+func (k *K8s) Namespaced() bool {
+	return k.namespaced
+}
+
+// managedNamespaces are the namespaces Argo CD manages when namespaced.
+func (k *K8s) managedNamespaces() []string {
+	set := map[string]bool{ARGOCD: true}
+	for _, c := range k.components {
+		set[c.Namespace] = true
+	}
+	for _, ns := range append(append([]string{}, k.destinations...), k.namespaces...) {
+		set[ns] = true
+	}
+	namespaces := make([]string, 0, len(set))
+	for ns := range set {
+		if ns != "" && ns != "*" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// CheckNamespaced returns everything a cluster admin has to provide before
+// pivot can run namespaced: the CRDs of the components it no longer
+// installs, the namespaces, the pivot ClusterIssuer and access to the
+// namespaces.
+func (k *K8s) CheckNamespaced(valkey bool) ([]Prerequisite, error) {
+	if k.dryRun {
+		k.log.Info("Dry run: Skipping namespaced prerequisites check")
+		return nil, nil
+	}
+	missing := []Prerequisite{}
+	required := crds
+	if valkey {
+		required = append(append([]crdPrerequisite{}, crds...), valkeyCRD)
+	}
+	served := map[string]map[string]bool{}
+	for _, crd := range required {
+		gv := crd.gv.String()
+		if _, ok := served[gv]; !ok {
+			resources, err := k.servedResources(gv)
+			if err != nil {
+				return nil, err
+			}
+			served[gv] = resources
+		}
+		if !served[gv][crd.resource] {
+			missing = append(missing, Prerequisite{
+				What: "CustomResourceDefinition " + crd.resource + "." + crd.gv.Group,
+				How:  crd.how,
+			})
+		}
+	}
+
+	for _, ns := range k.managedNamespaces() {
+		_, err := k.client.Resource(namespaceGVR).Get(k.ctx, ns, metav1.GetOptions{})
+		if err != nil && strings.Contains(err.Error(), "not found") {
+			missing = append(missing, Prerequisite{What: "Namespace " + ns, How: "create it"})
+			continue
+		}
+		// namespace admins usually cannot read namespaces, access is
+		// checked either way
+		for _, gvr := range namespacedAccess {
+			allowed, err := k.canCreate(ns, gvr)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				missing = append(missing, Prerequisite{
+					What: fmt.Sprintf("create %s in namespace %s", gvr.GroupResource(), ns),
+					How:  "grant the admin ClusterRole in the namespace with a RoleBinding",
+				})
+			}
+		}
+	}
+
+	_, err := k.client.Resource(clusterIssuerGVR).Get(k.ctx, IssuerName, metav1.GetOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		missing = append(missing, Prerequisite{
+			What: "ClusterIssuer " + IssuerName,
+			How:  "create a cert-manager ClusterIssuer named " + IssuerName + " for Gitea's certificates",
+		})
+	} else if err != nil {
+		k.log.Warnw("Cannot verify the ClusterIssuer exists", "error", err, NAME, IssuerName)
+	}
+	return missing, nil
+}
+
+// servedResources returns the resources the API server serves for the group
+// version gv.
+func (k *K8s) servedResources(gv string) (map[string]bool, error) {
+	resources := map[string]bool{}
+	if k.discovery == nil {
+		return nil, errors.New("no discovery client to check CRDs with")
+	}
+	list, err := k.discovery.ServerResourcesForGroupVersion(gv)
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return resources, nil
+	} else if err != nil {
+		k.log.Errorw("failed to discover resources", "error", err, "groupVersion", gv)
+		return nil, errors.Wrap(err, "")
+	}
+	for _, r := range list.APIResources {
+		resources[r.Name] = true
+	}
+	return resources, nil
+}
+
+// canCreate asks the API server whether the current user may create gvr in
+// namespace.
+func (k *K8s) canCreate(namespace string, gvr schema.GroupVersionResource) (bool, error) {
+	review := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "authorization.k8s.io/v1",
+			KIND:       "SelfSubjectAccessReview",
+			SPEC: map[string]interface{}{
+				"resourceAttributes": map[string]interface{}{
+					NAMESPACE:  namespace,
+					"verb":     "create",
+					"group":    gvr.Group,
+					"resource": gvr.Resource,
+				},
+			},
+		},
+	}
+	res, err := k.client.Resource(selfSubjectAccessReviewGVR).Create(k.ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		k.log.Errorw("failed to review access", "error", err, NAMESPACE, namespace, "resource", gvr.Resource)
+		return false, errors.Wrap(err, "")
+	}
+	allowed, _, _ := unstructured.NestedBool(res.Object, "status", "allowed")
+	return allowed, nil
+}
+
+// namespacedArgo builds what Argo CD needs to manage namespaces when
+// installed namespaced: a cluster secret restricting it to them, and a Role
+// and RoleBinding in each other namespace for its controller and server.
+func namespacedArgo(namespaces []string) []*unstructured.Unstructured {
+	objs := []*unstructured.Unstructured{
+		{
+			Object: map[string]interface{}{
+				APIVERSION: "v1",
+				KIND:       "Secret",
+				METADATA: map[string]interface{}{
+					NAME:      "in-cluster",
+					NAMESPACE: ARGOCD,
+					"labels": map[string]interface{}{
+						ClusterSecretLabel: "cluster",
+					},
+				},
+				"type": "Opaque",
+				"stringData": map[string]interface{}{
+					NAME:         "in-cluster",
+					"server":     InClusterServer,
+					"namespaces": strings.Join(namespaces, ","),
+					"config":     `{"tlsClientConfig":{"insecure":false}}`,
+				},
+			},
+		},
+	}
+	for _, ns := range namespaces {
+		// the Argo CD install already grants access to its own namespace
+		if ns == ARGOCD {
+			continue
+		}
+		objs = append(objs, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				APIVERSION: "rbac.authorization.k8s.io/v1",
+				KIND:       "Role",
+				METADATA: map[string]interface{}{
+					NAME:      argoManager,
+					NAMESPACE: ns,
+				},
+				"rules": []interface{}{
+					map[string]interface{}{
+						"apiGroups": []interface{}{"*"},
+						"resources": []interface{}{"*"},
+						"verbs":     []interface{}{"*"},
+					},
+				},
+			},
+		}, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				APIVERSION: "rbac.authorization.k8s.io/v1",
+				KIND:       "RoleBinding",
+				METADATA: map[string]interface{}{
+					NAME:      argoManager,
+					NAMESPACE: ns,
+				},
+				"roleRef": map[string]interface{}{
+					"apiGroup": "rbac.authorization.k8s.io",
+					KIND:       "Role",
+					NAME:       argoManager,
+				},
+				"subjects": []interface{}{
+					map[string]interface{}{
+						KIND:      "ServiceAccount",
+						NAME:      "argocd-application-controller",
+						NAMESPACE: ARGOCD,
+					},
+					map[string]interface{}{
+						KIND:      "ServiceAccount",
+						NAME:      "argocd-server",
+						NAMESPACE: ARGOCD,
+					},
+				},
+			},
+		})
+	}
+	return objs
+}
@@ -0,0 +1,165 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func namespace(name string) *unstructured.Unstructured {
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(name)
+	return ns
+}
+
+// newNamespacedK8s returns a K8s whose discovery serves resources, where
+// every access review is allowed.
+func newNamespacedK8s(t *testing.T, resources []*metav1.APIResourceList, objects ...runtime.Object) *K8s {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...)
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if err := unstructured.SetNestedField(review.Object, true, "status", "allowed"); err != nil {
+			return true, nil, err
+		}
+		return true, review, nil
+	})
+	disc := &discoveryfake.FakeDiscovery{Fake: &clienttesting.Fake{Resources: resources}}
+	k := NewK8sForClient(context.TODO(), zap.NewNop().Sugar(), client, disc, fakeMapper())
+	k.SetNamespaced(nil)
+	return k
+}
+
+func TestSetNamespaced(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	k.SetNamespaced(nil)
+	for _, c := range k.Components() {
+		if clusterComponents[c.Path] {
+			t.Errorf("Expected %s to be left to a cluster admin", c.Path)
+		}
+	}
+	if !k.Namespaced() || len(k.Components()) == 0 {
+		t.Errorf("Expected the namespaced components, got %v", k.Components())
+	}
+}
+
+func TestCheckNamespaced(t *testing.T) {
+	k := newNamespacedK8s(t, []*metav1.APIResourceList{
+		{
+			GroupVersion: "argoproj.io/v1alpha1",
+			APIResources: []metav1.APIResource{{Name: "applications"}, {Name: "applicationsets"}, {Name: "appprojects"}},
+		},
+	}, namespace(ARGOCD))
+	missing, err := k.CheckNamespaced(false)
+	if err != nil {
+		t.Fatalf("CheckNamespaced failed %v", err)
+	}
+	report := []string{}
+	for _, m := range missing {
+		report = append(report, m.What)
+	}
+	got := strings.Join(report, "\n")
+	for _, want := range []string{
+		"CustomResourceDefinition certificates.cert-manager.io",
+		"CustomResourceDefinition gitea.hyperspike.io",
+		"CustomResourceDefinition postgresqls.acid.zalan.do",
+		"Namespace default",
+		"ClusterIssuer " + IssuerName,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q to be reported missing, got\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"applications.argoproj.io", "Namespace argocd", "valkeys", "create "} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("Expected %q to not be reported missing, got\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestNamespacedArgoInit(t *testing.T) {
+	k := newNamespacedK8s(t, nil)
+	if err := k.CreateArgoInit("", "pivot", "secret"); err != nil {
+		t.Fatalf("CreateArgoInit failed %v", err)
+	}
+	kinds := map[string]bool{}
+	for _, obj := range k.list[ARGOCD] {
+		kinds[obj.GetKind()] = true
+		if obj.GetKind() == "AppProject" {
+			whitelist, _, _ := unstructured.NestedSlice(obj.Object, SPEC, "clusterResourceWhitelist")
+			if len(whitelist) != 0 {
+				t.Errorf("Expected no cluster-scoped kinds in the project, got %v", whitelist)
+			}
+		}
+	}
+	for _, kind := range []string{"Secret", "Role", "RoleBinding"} {
+		if !kinds[kind] {
+			t.Errorf("Expected a %s granting Argo CD access to the namespaces", kind)
+		}
+	}
+}
+
+func TestNamespacedManagesNamespaces(t *testing.T) {
+	k := newNamespacedK8s(t, nil, namespace(ARGOCD), namespace(DEFAULT))
+	k.SetNamespaced([]string{"team-a"})
+	if err := k.SetDestinations([]string{"apps"}); err != nil {
+		t.Fatalf("SetDestinations failed %v", err)
+	}
+	if got := strings.Join(k.managedNamespaces(), ","); got != "apps,argocd,default,team-a" {
+		t.Errorf("Expected argocd, the components' namespace, the destination and team-a to be managed, got %s", got)
+	}
+	missing, err := k.CheckNamespaced(false)
+	if err != nil {
+		t.Fatalf("CheckNamespaced failed %v", err)
+	}
+	reported := map[string]bool{}
+	for _, m := range missing {
+		reported[m.What] = true
+	}
+	if !reported["Namespace team-a"] || !reported["Namespace apps"] || reported["Namespace default"] {
+		t.Errorf("Expected the missing managed namespaces to be reported, got %v", missing)
+	}
+
+	if err := k.CreateArgoInit("", "pivot", "secret"); err != nil {
+		t.Fatalf("CreateArgoInit failed %v", err)
+	}
+	roles := map[string]bool{}
+	for _, obj := range k.list[ARGOCD] {
+		switch obj.GetKind() {
+		case "Secret":
+			if ns, _, _ := unstructured.NestedString(obj.Object, "stringData", "namespaces"); ns != "apps,argocd,default,team-a" {
+				t.Errorf("Expected Argo CD to be restricted to the managed namespaces, got %s", ns)
+			}
+		case "Role":
+			roles[obj.GetNamespace()] = true
+		}
+	}
+	if !roles["team-a"] || !roles["apps"] || !roles[DEFAULT] || roles[ARGOCD] {
+		t.Errorf("Expected a Role in every managed namespace but argocd, got %v", roles)
+	}
+}
+
+func TestNamespacedRefusesClusterScoped(t *testing.T) {
+	k := newNamespacedK8s(t, nil)
+	crb := &unstructured.Unstructured{}
+	crb.SetAPIVersion("rbac.authorization.k8s.io/v1")
+	crb.SetKind("ClusterRoleBinding")
+	crb.SetName("argocd-server")
+	gvr, namespaced := k.resourceFor(crb.GroupVersionKind())
+	if namespaced || gvr.Resource != "clusterrolebindings" {
+		t.Fatalf("Expected ClusterRoleBinding to be cluster-scoped")
+	}
+	if err := k.applyObject(INIT, crb); err == nil || !strings.Contains(err.Error(), "cluster admin") {
+		t.Errorf("Expected applying a cluster-scoped object to fail, got %v", err)
+	}
+}
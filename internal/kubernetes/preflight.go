@@ -0,0 +1,274 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CheckStatus is the outcome of a preflight check.
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	// CheckWarn could not be verified, usually for lack of access
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// Check is the result of a single preflight check.
+type Check struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message,omitempty"`
+}
+
+// Failed reports whether any of checks failed.
+func Failed(checks []Check) bool {
+	for _, c := range checks {
+		if c.Status == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// PreflightOptions is what the cluster is checked against.
+type PreflightOptions struct {
+	Namespaced bool
+	Valkey     bool
+	// Profile is checked against the capacity of the schedulable nodes, the
+	// capacity is not checked for the zero Profile
+	Profile Profile
+}
+
+// minKubernetes is the oldest Kubernetes minor release each component is
+// expected to run on. Upstream support ranges move with every release, so
+// an older cluster is only warned about.
+var minKubernetes = map[string]int{
+	ARGOCD:              30,
+	"cert-manager":      29,
+	"postgres-operator": 27,
+	"valkey-operator":   28,
+	"gitea-operator":    28,
+}
+
+// clusterAccess are the cluster-scoped resources pivot creates when it is
+// not namespaced.
+var clusterAccess = []schema.GroupVersionResource{
+	namespaceGVR,
+	{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"},
+	clusterRoleGVR,
+	clusterRoleBindingGVR,
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"},
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "mutatingwebhookconfigurations"},
+}
+
+var (
+	crdGVR = schema.GroupVersionResource{
+		Group:    "apiextensions.k8s.io",
+		Version:  "v1",
+		Resource: "customresourcedefinitions",
+	}
+	storageClassGVR = schema.GroupVersionResource{
+		Group:    "storage.k8s.io",
+		Version:  "v1",
+		Resource: "storageclasses",
+	}
+	nodeGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "nodes",
+	}
+)
+
+// Preflight checks the cluster can run the platform without changing
+// anything in it: the Kubernetes version, access, a default StorageClass,
+// installs pivot would conflict with and the capacity of the nodes.
+func (k *K8s) Preflight(opts PreflightOptions) ([]Check, error) {
+	if k.dryRun {
+		k.log.Info("Dry run: Skipping preflight checks")
+		return nil, nil
+	}
+	checks := []Check{}
+	for _, check := range []struct {
+		name string
+		run  func(PreflightOptions) (CheckStatus, string, error)
+	}{
+		{"kubernetes version", k.checkVersion},
+		{"access", k.checkAccess},
+		{"default storage class", k.checkStorageClass},
+		{"existing installs", k.checkConflicts},
+		{"node capacity", k.checkCapacity},
+	} {
+		status, message, err := check.run(opts)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, Check{Name: check.name, Status: status, Message: message})
+	}
+	return checks, nil
+}
+
+func (k *K8s) checkVersion(opts PreflightOptions) (CheckStatus, string, error) {
+	if k.discovery == nil {
+		return CheckWarn, "no discovery client", nil
+	}
+	info, err := k.discovery.ServerVersion()
+	if err != nil {
+		k.log.Errorw("failed to get server version", "error", err)
+		return "", "", errors.Wrap(err, "")
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if err != nil || info.Major != "1" {
+		return CheckWarn, "unrecognized version " + info.GitVersion, nil
+	}
+	unsupported := []string{}
+	for component, min := range minKubernetes {
+		if opts.Namespaced && clusterComponents[component] {
+			continue
+		}
+		if minor < min {
+			unsupported = append(unsupported, fmt.Sprintf("%s needs 1.%d", component, min))
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return CheckWarn, info.GitVersion + " may be too old: " + strings.Join(unsupported, ", "), nil
+	}
+	return CheckPass, info.GitVersion, nil
+}
+
+func (k *K8s) checkAccess(opts PreflightOptions) (CheckStatus, string, error) {
+	if opts.Namespaced {
+		missing, err := k.CheckNamespaced(opts.Valkey)
+		if err != nil {
+			return "", "", err
+		}
+		if len(missing) > 0 {
+			report := make([]string, 0, len(missing))
+			for _, m := range missing {
+				report = append(report, m.String())
+			}
+			return CheckFail, strings.Join(report, "; "), nil
+		}
+		return CheckPass, "namespaced prerequisites are installed", nil
+	}
+	denied := []string{}
+	for _, gvr := range clusterAccess {
+		allowed, err := k.canCreate("", gvr)
+		if err != nil {
+			return "", "", err
+		}
+		if !allowed {
+			denied = append(denied, gvr.GroupResource().String())
+		}
+	}
+	for _, ns := range k.managedNamespaces() {
+		for _, gvr := range namespacedAccess {
+			allowed, err := k.canCreate(ns, gvr)
+			if err != nil {
+				return "", "", err
+			}
+			if !allowed {
+				denied = append(denied, gvr.GroupResource().String()+" in "+ns)
+			}
+		}
+	}
+	if len(denied) > 0 {
+		return CheckFail, "cannot create " + strings.Join(denied, ", ") + " (run with --namespaced without cluster-admin)", nil
+	}
+	return CheckPass, "cluster-admin", nil
+}
+
+func (k *K8s) checkStorageClass(opts PreflightOptions) (CheckStatus, string, error) {
+	list, err := k.client.Resource(storageClassGVR).List(k.ctx, metav1.ListOptions{})
+	if err != nil && strings.Contains(err.Error(), "forbidden") {
+		return CheckWarn, "cannot list storage classes", nil
+	} else if err != nil {
+		k.log.Errorw("failed to list storage classes", "error", err)
+		return "", "", errors.Wrap(err, "")
+	}
+	for _, sc := range list.Items {
+		if sc.GetAnnotations()["storageclass.kubernetes.io/is-default-class"] == "true" {
+			return CheckPass, sc.GetName(), nil
+		}
+	}
+	return CheckFail, "no default StorageClass, Gitea and Postgres need persistent volumes", nil
+}
+
+// checkConflicts looks for cert-manager and Argo CD installed by something
+// other than pivot, which pivot would take over.
+func (k *K8s) checkConflicts(opts PreflightOptions) (CheckStatus, string, error) {
+	if opts.Namespaced {
+		return CheckPass, "namespaced, installed by a cluster admin", nil
+	}
+	conflicts := []string{}
+	for component, crd := range map[string]string{
+		"cert-manager": "certificates.cert-manager.io",
+		ARGOCD:         "applications.argoproj.io",
+	} {
+		obj, err := k.client.Resource(crdGVR).Get(k.ctx, crd, metav1.GetOptions{})
+		if err != nil && strings.Contains(err.Error(), "not found") {
+			continue
+		} else if err != nil && strings.Contains(err.Error(), "forbidden") {
+			return CheckWarn, "cannot read CRDs", nil
+		} else if err != nil {
+			k.log.Errorw("failed to get CRD", "error", err, NAME, crd)
+			return "", "", errors.Wrap(err, "")
+		}
+		if obj.GetLabels()[PartOfLabel] != PIVOT {
+			conflicts = append(conflicts, component)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return CheckFail, strings.Join(conflicts, ", ") + " already installed and not managed by pivot", nil
+	}
+	return CheckPass, "", nil
+}
+
+func (k *K8s) checkCapacity(opts PreflightOptions) (CheckStatus, string, error) {
+	need := opts.Profile
+	if need.CPU.IsZero() && need.Memory.IsZero() && need.Nodes == 0 {
+		return CheckPass, "no requirement", nil
+	}
+	list, err := k.client.Resource(nodeGVR).List(k.ctx, metav1.ListOptions{})
+	if err != nil && strings.Contains(err.Error(), "forbidden") {
+		return CheckWarn, "cannot list nodes", nil
+	} else if err != nil {
+		k.log.Errorw("failed to list nodes", "error", err)
+		return "", "", errors.Wrap(err, "")
+	}
+	cpu, memory := resource.Quantity{}, resource.Quantity{}
+	nodes := 0
+	for _, node := range list.Items {
+		if unschedulable, _, _ := unstructured.NestedBool(node.Object, SPEC, "unschedulable"); unschedulable {
+			continue
+		}
+		nodes++
+		allocatable, _, _ := unstructured.NestedStringMap(node.Object, "status", "allocatable")
+		if q, err := resource.ParseQuantity(allocatable["cpu"]); err == nil {
+			cpu.Add(q)
+		}
+		if q, err := resource.ParseQuantity(allocatable["memory"]); err == nil {
+			memory.Add(q)
+		}
+	}
+	message := fmt.Sprintf("%s CPU, %s memory allocatable; %s CPU, %s memory needed", cpu.String(), memory.String(), need.CPU.String(), need.Memory.String())
+	if nodes < need.Nodes {
+		return CheckFail, fmt.Sprintf("%d schedulable nodes, %d needed; %s", nodes, need.Nodes, message), nil
+	}
+	if cpu.Cmp(need.CPU) < 0 || memory.Cmp(need.Memory) < 0 {
+		return CheckFail, message, nil
+	}
+	return CheckPass, message, nil
+}
@@ -0,0 +1,114 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func object(apiVersion, kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+func node(name, cpu, memory string) *unstructured.Unstructured {
+	n := object("v1", "Node", name)
+	_ = unstructured.SetNestedStringMap(n.Object, map[string]string{"cpu": cpu, "memory": memory}, "status", "allocatable")
+	return n
+}
+
+// newPreflightK8s returns a K8s on a cluster running minor, where access
+// reviews are answered with allowed.
+func newPreflightK8s(t *testing.T, minor string, allowed bool, objects ...runtime.Object) *K8s {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...)
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if err := unstructured.SetNestedField(review.Object, allowed, "status", "allowed"); err != nil {
+			return true, nil, err
+		}
+		return true, review, nil
+	})
+	disc := &discoveryfake.FakeDiscovery{
+		Fake:               &clienttesting.Fake{},
+		FakedServerVersion: &version.Info{Major: "1", Minor: minor, GitVersion: "v1." + minor + ".0"},
+	}
+	return NewK8sForClient(context.TODO(), zap.NewNop().Sugar(), client, disc, fakeMapper())
+}
+
+func checkStatus(checks []Check, name string) Check {
+	for _, c := range checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	return Check{}
+}
+
+func TestPreflight(t *testing.T) {
+	sc := object("storage.k8s.io/v1", "StorageClass", "standard")
+	sc.SetAnnotations(map[string]string{"storageclass.kubernetes.io/is-default-class": "true"})
+	profile, err := GetProfile("")
+	if err != nil {
+		t.Fatalf("GetProfile failed %v", err)
+	}
+	opts := PreflightOptions{Profile: profile}
+
+	k := newPreflightK8s(t, "31", true, sc, node("a", "2", "4Gi"), node("b", "2000m", "8Gi"))
+	checks, err := k.Preflight(opts)
+	if err != nil {
+		t.Fatalf("Preflight failed %v", err)
+	}
+	if Failed(checks) {
+		t.Errorf("Expected every check to pass, got %+v", checks)
+	}
+
+	crd := object("apiextensions.k8s.io/v1", "CustomResourceDefinition", "certificates.cert-manager.io")
+	k = newPreflightK8s(t, "28", false, crd, node("a", "1", "1Gi"))
+	checks, err = k.Preflight(opts)
+	if err != nil {
+		t.Fatalf("Preflight failed %v", err)
+	}
+	if c := checkStatus(checks, "kubernetes version"); c.Status != CheckWarn || !strings.Contains(c.Message, "argocd needs 1.30") {
+		t.Errorf("Expected kubernetes version to warn with %q, got %+v", "argocd needs 1.30", c)
+	}
+	for name, want := range map[string]string{
+		"access":                "cannot create namespaces",
+		"default storage class": "no default StorageClass",
+		"existing installs":     "cert-manager",
+		"node capacity":         "1 CPU, 1Gi memory allocatable",
+	} {
+		c := checkStatus(checks, name)
+		if c.Status != CheckFail || !strings.Contains(c.Message, want) {
+			t.Errorf("Expected %s to fail with %q, got %+v", name, want, c)
+		}
+	}
+}
+
+func TestPreflightIgnoresPivotInstalls(t *testing.T) {
+	crd := object("apiextensions.k8s.io/v1", "CustomResourceDefinition", "applications.argoproj.io")
+	crd.SetLabels(map[string]string{PartOfLabel: PIVOT})
+	k := newPreflightK8s(t, "31", true, crd)
+	checks, err := k.Preflight(PreflightOptions{Profile: Profile{Memory: resource.MustParse("1Gi")}})
+	if err != nil {
+		t.Fatalf("Preflight failed %v", err)
+	}
+	if c := checkStatus(checks, "existing installs"); c.Status != CheckPass {
+		t.Errorf("Expected a pivot install not to conflict, got %+v", c)
+	}
+	if c := checkStatus(checks, "node capacity"); c.Status != CheckFail {
+		t.Errorf("Expected no nodes to fail the capacity check, got %+v", c)
+	}
+}
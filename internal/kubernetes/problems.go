@@ -0,0 +1,324 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Problem is a failing pod or warning Event in a namespace pivot works on.
+type Problem struct {
+	Component string
+	Namespace string
+	// Object is the Kind/name the problem is about
+	Object  string
+	Reason  string
+	Message string
+	// apiVersion of Object, to look up the objects controlling it
+	apiVersion string
+}
+
+var (
+	eventGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "events",
+	}
+	podGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "pods",
+	}
+)
+
+// problemReasons are the warning Event reasons worth surfacing, most other
+// warnings are transient while components start.
+var problemReasons = map[string]bool{
+	"FailedScheduling":       true,
+	"FailedMount":            true,
+	"FailedAttachVolume":     true,
+	"FailedBinding":          true,
+	"ProvisioningFailed":     true,
+	"FailedCreate":           true,
+	"FailedCreatePodSandBox": true,
+	"Failed":                 true,
+	"BackOff":                true,
+}
+
+// waitingReasons are the container waiting reasons of a failing pod.
+var waitingReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// problemsRetry is how long a watch waits before it is restarted.
+var problemsRetry = 5 * time.Second
+
+// maxOwners bounds the controller references followed from the object of a
+// problem, a Pod of a CronJob is three levels down.
+const maxOwners = 5
+
+// WatchProblems logs failing pods and warning Events in the namespaces of
+// the components in the background until ctx is done, each problem once.
+// The namespaces of components added later are watched from then on.
+func (k *K8s) WatchProblems(ctx context.Context) {
+	if k.dryRun {
+		return
+	}
+	k.problems = make(chan map[string]string)
+	k.problemsDone = ctx.Done()
+	go k.watchProblems(ctx, k.namespaceComponents(), k.problems, func(p Problem) {
+		k.log.Warnw(p.Reason, "component", p.Component, NAMESPACE, p.Namespace, "object", p.Object, "message", p.Message)
+	})
+}
+
+// watchAdded hands the namespaces of the components to a running
+// WatchProblems.
+func (k *K8s) watchAdded() {
+	if k.problems == nil {
+		return
+	}
+	select {
+	case k.problems <- k.namespaceComponents():
+	case <-k.problemsDone:
+	}
+}
+
+// watchProblems reports the problems in namespaces, and in those received
+// from added, until ctx is done. Each is mapped to the component whose
+// inventory holds its object or an object controlling it, and to the
+// component of its namespace otherwise.
+func (k *K8s) watchProblems(ctx context.Context, namespaces map[string]string, added <-chan map[string]string, report func(Problem)) {
+	// Events from before this run are left out, allowing for clock skew
+	since := time.Now().Add(-time.Minute)
+	var mu sync.Mutex
+	seen := map[Problem]bool{}
+	once := func(p Problem) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		if component := k.problemComponent(p); component != "" {
+			p.Component = component
+		}
+		report(p)
+	}
+	var wg sync.WaitGroup
+	watched := map[string]bool{}
+	for {
+		for ns, component := range namespaces {
+			if watched[ns] {
+				continue
+			}
+			watched[ns] = true
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				k.watchNamespace(ctx, ns, eventGVR, func(obj *unstructured.Unstructured) []Problem {
+					return eventProblems(obj, component, since)
+				}, once)
+			}()
+			go func() {
+				defer wg.Done()
+				k.watchNamespace(ctx, ns, podGVR, func(obj *unstructured.Unstructured) []Problem {
+					return podProblems(obj, component)
+				}, once)
+			}()
+		}
+		select {
+		case namespaces = <-added:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// problemComponent returns the component whose stored inventory holds the
+// object of p or an object controlling it, "" when pivot applied none of
+// them.
+func (k *K8s) problemComponent(p Problem) string {
+	owned, err := k.inventoryObjects()
+	if err != nil {
+		return ""
+	}
+	kind, name, _ := strings.Cut(p.Object, "/")
+	return k.ownerComponent(owned, p.Namespace, p.apiVersion, kind, name, nil)
+}
+
+// inventoryObjects maps every object of the stored inventories to its
+// component.
+func (k *K8s) inventoryObjects() (map[objectKey]string, error) {
+	inventories, err := k.ListInventories()
+	if err != nil {
+		return nil, err
+	}
+	owned := map[objectKey]string{}
+	for _, inv := range inventories {
+		for _, e := range inv.Entries {
+			owned[e.key()] = inv.Component
+		}
+	}
+	return owned, nil
+}
+
+// ownerComponent returns the component of owned holding the object or one
+// controlling it, following controller references up to maxOwners levels,
+// "" when there is none. obj is the object itself when already fetched.
+func (k *K8s) ownerComponent(owned map[objectKey]string, namespace, apiVersion, kind, name string, obj *unstructured.Unstructured) string {
+	for i := 0; i < maxOwners; i++ {
+		gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+		if component, ok := owned[objectKey{gvk.Group, kind, namespace, name}]; ok {
+			return component
+		}
+		if obj == nil {
+			gvr, _ := k.resourceFor(gvk)
+			var err error
+			obj, err = k.client.Resource(gvr).Namespace(namespace).Get(k.ctx, name, metav1.GetOptions{})
+			if err != nil {
+				k.log.Debugw("cannot look up the owner of an object", "error", err, NAMESPACE, namespace, KIND, kind, NAME, name)
+				return ""
+			}
+		}
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			return ""
+		}
+		apiVersion, kind, name = ref.APIVersion, ref.Kind, ref.Name
+		obj = nil
+	}
+	return ""
+}
+
+// namespaceComponents maps each namespace of the components to the first
+// component deployed to it.
+func (k *K8s) namespaceComponents() map[string]string {
+	namespaces := map[string]string{}
+	for _, c := range k.components {
+		if _, ok := namespaces[c.Namespace]; !ok && c.Namespace != "" {
+			namespaces[c.Namespace] = c.Path
+		}
+	}
+	return namespaces
+}
+
+// watchNamespace lists then watches gvr in ns, reporting the problems of
+// every object, and restarts the watch when it ends until ctx is done.
+func (k *K8s) watchNamespace(ctx context.Context, ns string, gvr schema.GroupVersionResource, problems func(*unstructured.Unstructured) []Problem, report func(Problem)) {
+	for {
+		list, err := k.client.Resource(gvr).Namespace(ns).List(ctx, metav1.ListOptions{})
+		if err != nil && strings.Contains(err.Error(), "forbidden") {
+			k.log.Debugw("not watching for problems", "error", err, NAMESPACE, ns, "resource", gvr.Resource)
+			return
+		}
+		if err == nil {
+			for i := range list.Items {
+				for _, p := range problems(&list.Items[i]) {
+					report(p)
+				}
+			}
+			w, err := k.client.Resource(gvr).Namespace(ns).Watch(ctx, metav1.ListOptions{ResourceVersion: list.GetResourceVersion()})
+			if err == nil {
+				watchEvents(ctx, w, problems, report)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(problemsRetry):
+		}
+	}
+}
+
+// watchEvents reports the problems of the objects w sees until it ends or
+// ctx is done.
+func watchEvents(ctx context.Context, w watch.Interface, problems func(*unstructured.Unstructured) []Problem, report func(Problem)) {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			obj, ok := ev.Object.(*unstructured.Unstructured)
+			if !ok || (ev.Type != watch.Added && ev.Type != watch.Modified) {
+				continue
+			}
+			for _, p := range problems(obj) {
+				report(p)
+			}
+		}
+	}
+}
+
+// eventProblems returns the problem a warning Event reports, failed
+// webhook calls included, ignoring Events last seen before since.
+func eventProblems(event *unstructured.Unstructured, component string, since time.Time) []Problem {
+	if t, _, _ := unstructured.NestedString(event.Object, "type"); t != "Warning" {
+		return nil
+	}
+	reason, _, _ := unstructured.NestedString(event.Object, "reason")
+	message, _, _ := unstructured.NestedString(event.Object, "message")
+	if !problemReasons[reason] && !strings.Contains(message, "failed calling webhook") {
+		return nil
+	}
+	if lastSeen(event).Before(since) {
+		return nil
+	}
+	involved, _, _ := unstructured.NestedStringMap(event.Object, "involvedObject")
+	return []Problem{{
+		Component:  component,
+		Namespace:  event.GetNamespace(),
+		Object:     involved["kind"] + "/" + involved[NAME],
+		Reason:     reason,
+		Message:    message,
+		apiVersion: involved["apiVersion"],
+	}}
+}
+
+// podProblems returns the containers of pod waiting on a failure and
+// whether it cannot be scheduled.
+func podProblems(pod *unstructured.Unstructured, component string) []Problem {
+	problems := []Problem{}
+	object := "Pod/" + pod.GetName()
+	conditions, _, _ := unstructured.NestedSlice(pod.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "PodScheduled" || cond["status"] != "False" {
+			continue
+		}
+		message, _ := cond["message"].(string)
+		problems = append(problems, Problem{component, pod.GetNamespace(), object, "Unschedulable", message, "v1"})
+	}
+	for _, field := range []string{"initContainerStatuses", "containerStatuses"} {
+		statuses, _, _ := unstructured.NestedSlice(pod.Object, "status", field)
+		for _, s := range statuses {
+			status, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			reason, _, _ := unstructured.NestedString(status, "state", "waiting", "reason")
+			if !waitingReasons[reason] {
+				continue
+			}
+			message, _, _ := unstructured.NestedString(status, "state", "waiting", "message")
+			name, _, _ := unstructured.NestedString(status, NAME)
+			problems = append(problems, Problem{component, pod.GetNamespace(), object, reason, name + ": " + message, "v1"})
+		}
+	}
+	return problems
+}
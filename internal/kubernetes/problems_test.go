@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func event(namespace, name, reason, message string, last time.Time) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Event",
+		METADATA: map[string]interface{}{
+			NAME:      name,
+			NAMESPACE: namespace,
+		},
+		"type":          "Warning",
+		"reason":        reason,
+		"message":       message,
+		"lastTimestamp": last.UTC().Format(time.RFC3339),
+		"involvedObject": map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "Pod",
+			NAME:       "gitea-0",
+		},
+	}}
+}
+
+func pod(namespace, name string, status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "v1",
+		KIND:       "Pod",
+		METADATA: map[string]interface{}{
+			NAME:      name,
+			NAMESPACE: namespace,
+		},
+		"status": status,
+	}}
+}
+
+func TestEventProblems(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		event *unstructured.Unstructured
+		want  int
+	}{
+		{event(DEFAULT, "a", "FailedScheduling", "pod has unbound immediate PersistentVolumeClaims", now), 1},
+		{event(DEFAULT, "b", "FailedCreate", "Internal error occurred: failed calling webhook \"webhook.cert-manager.io\"", now), 1},
+		{event(DEFAULT, "c", "Unhealthy", "Readiness probe failed", now), 0},
+		{event(DEFAULT, "d", "FailedMount", "MountVolume.SetUp failed", now.Add(-time.Hour)), 0},
+	} {
+		got := eventProblems(tc.event, GITEA, now.Add(-time.Minute))
+		if len(got) != tc.want {
+			t.Errorf("Expected %d problems for %s, got %+v", tc.want, tc.event.GetName(), got)
+		}
+	}
+}
+
+func TestPodProblems(t *testing.T) {
+	p := pod(DEFAULT, "gitea-0", map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "PodScheduled", "status": "False", "message": "0/1 nodes are available"},
+		},
+		"containerStatuses": []interface{}{
+			map[string]interface{}{
+				NAME:    GITEA,
+				"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "ImagePullBackOff", "message": "Back-off pulling image"}},
+			},
+			map[string]interface{}{
+				NAME:    "postgres",
+				"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "ContainerCreating"}},
+			},
+		},
+	})
+	got := podProblems(p, GITEA)
+	if len(got) != 2 || got[0].Reason != "Unschedulable" || got[1].Reason != "ImagePullBackOff" || got[1].Object != "Pod/gitea-0" {
+		t.Errorf("Expected an unschedulable and an image pull problem, got %+v", got)
+	}
+}
+
+// owned returns a namespace/name object of kind controlled by owner.
+func owned(apiVersion, kind, namespace, name string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	controller := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: owner.GetAPIVersion(),
+		Kind:       owner.GetKind(),
+		Name:       owner.GetName(),
+		Controller: &controller,
+	}})
+	return obj
+}
+
+func crashing(p *unstructured.Unstructured) *unstructured.Unstructured {
+	p.Object["status"] = map[string]interface{}{
+		"containerStatuses": []interface{}{
+			map[string]interface{}{
+				NAME:    "main",
+				"state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "CrashLoopBackOff"}},
+			},
+		},
+	}
+	return p
+}
+
+func TestWatchProblems(t *testing.T) {
+	gitea := object("hyperspike.io/v1", "Gitea", GITEA)
+	gitea.SetNamespace(DEFAULT)
+	runner := object("apps/v1", "Deployment", "act-runner")
+	runner.SetNamespace(DEFAULT)
+	backup := object("batch/v1", "CronJob", "pivot-backup")
+	backup.SetNamespace(DEFAULT)
+
+	giteaSet := owned("apps/v1", "StatefulSet", DEFAULT, GITEA, gitea)
+	runnerSet := owned("apps/v1", "ReplicaSet", DEFAULT, "act-runner-5d8f", runner)
+	backupJob := owned("batch/v1", "Job", DEFAULT, "pivot-backup-2901", backup)
+	k, _ := newFakeK8s(t,
+		giteaSet, runnerSet, backupJob,
+		owned("v1", "Pod", DEFAULT, "gitea-0", giteaSet),
+		crashing(owned("v1", "Pod", DEFAULT, "act-runner-5d8f-x2", runnerSet)),
+		crashing(owned("v1", "Pod", DEFAULT, "pivot-backup-2901-q", backupJob)),
+		crashing(pod(ARGOCD, "argocd-server", nil)),
+		event(DEFAULT, "pvc", "FailedScheduling", "unbound PersistentVolumeClaims", time.Now()),
+	)
+	for component, obj := range map[string]*unstructured.Unstructured{GITEA: gitea, ACTIONS: runner, BACKUP: backup} {
+		gvr, _ := k.resourceFor(obj.GroupVersionKind())
+		k.track(component, gvr, obj)
+		if err := k.SaveInventory(component); err != nil {
+			t.Fatalf("SaveInventory failed %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	var mu sync.Mutex
+	got := map[string]Problem{}
+	done := make(chan struct{})
+	added := make(chan map[string]string)
+	go func() {
+		defer close(done)
+		k.watchProblems(ctx, map[string]string{ARGOCD: ARGOCD}, added, func(p Problem) {
+			mu.Lock()
+			defer mu.Unlock()
+			got[p.Object] = p
+		})
+	}()
+	// namespaces of components added later are watched too
+	added <- k.namespaceComponents()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 4 {
+			break
+		}
+	}
+	cancel()
+	<-done
+	for object, component := range map[string]string{
+		"Pod/argocd-server":       ARGOCD,
+		"Pod/gitea-0":             GITEA,
+		"Pod/act-runner-5d8f-x2":  ACTIONS,
+		"Pod/pivot-backup-2901-q": BACKUP,
+	} {
+		if p, ok := got[object]; !ok || p.Component != component {
+			t.Errorf("Expected the problem of %s to belong to %s, got %+v", object, component, p)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	goyaml "gopkg.in/yaml.v2"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Profile sizes the platform pivot installs.
+type Profile struct {
+	Name string
+	// Argo is the Argo CD edition: core, standard or ha
+	Argo string
+	// GiteaReplicas and PostgresReplicas are set on the Gitea CR
+	GiteaReplicas    int64
+	PostgresReplicas int64
+	// Valkey enables valkey whether or not --valkey is set
+	Valkey bool
+	// Requests are the container requests of Argo CD, cert-manager, the
+	// operators and, as the default of Gitea's namespace, of the pods the
+	// gitea-operator creates. No limits are set: one limit cannot fit every
+	// component, they are added to a component's resources.yaml instead.
+	Requests map[string]string
+	// CPU, Memory and Nodes are the allocatable capacity and schedulable
+	// nodes the profile needs
+	CPU    resource.Quantity
+	Memory resource.Quantity
+	Nodes  int
+}
+
+// DefaultProfile is the profile used when none is selected.
+const DefaultProfile = "standard"
+
+// Profiles are the profiles selectable with --profile.
+var Profiles = map[string]Profile{
+	"minimal": {
+		Name:             "minimal",
+		Argo:             "core",
+		GiteaReplicas:    1,
+		PostgresReplicas: 1,
+		Requests:         map[string]string{"cpu": "10m", "memory": "32Mi"},
+		CPU:              resource.MustParse("1"),
+		Memory:           resource.MustParse("2Gi"),
+		Nodes:            1,
+	},
+	"standard": {
+		Name:             "standard",
+		Argo:             "standard",
+		GiteaReplicas:    1,
+		PostgresReplicas: 1,
+		Requests:         map[string]string{"cpu": "50m", "memory": "64Mi"},
+		CPU:              resource.MustParse("2"),
+		Memory:           resource.MustParse("4Gi"),
+		Nodes:            1,
+	},
+	"ha": {
+		Name:             "ha",
+		Argo:             "ha",
+		GiteaReplicas:    2,
+		PostgresReplicas: 3,
+		Valkey:           true,
+		Requests:         map[string]string{"cpu": "100m", "memory": "128Mi"},
+		CPU:              resource.MustParse("6"),
+		Memory:           resource.MustParse("12Gi"),
+		Nodes:            3,
+	},
+}
+
+// GetProfile returns the profile called name, DefaultProfile when empty.
+func GetProfile(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(Profiles))
+		for n := range Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// ResourcePatch returns the JSON patch setting the resources of the first
+// container of a workload, empty without requests.
+func (p Profile) ResourcePatch() (string, error) {
+	if len(p.Requests) == 0 {
+		return "", nil
+	}
+	data, err := goyaml.Marshal([]map[string]interface{}{
+		{
+			"op":    "add",
+			"path":  "/spec/template/spec/containers/0/resources",
+			"value": map[string]interface{}{"requests": p.Requests},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "")
+	}
+	return string(data), nil
+}
+
+var limitRangeGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "limitranges",
+}
+
+// defaultRequests is the LimitRange giving the containers of namespace that
+// set no requests of their own requests, the Gitea and Postgres pods are
+// created by the gitea-operator and cannot be patched in the repo.
+func defaultRequests(namespace string, requests map[string]string) *unstructured.Unstructured {
+	defaults := map[string]interface{}{}
+	for resource, quantity := range requests {
+		defaults[resource] = quantity
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "v1",
+			KIND:       "LimitRange",
+			METADATA: map[string]interface{}{
+				NAME:      "pivot-default-requests",
+				NAMESPACE: namespace,
+			},
+			SPEC: map[string]interface{}{
+				"limits": []interface{}{
+					map[string]interface{}{
+						"type":           "Container",
+						"defaultRequest": defaults,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGetProfile(t *testing.T) {
+	p, err := GetProfile("")
+	if err != nil || p.Name != DefaultProfile {
+		t.Errorf("Expected the %s profile, got %v %v", DefaultProfile, p.Name, err)
+	}
+	if _, err := GetProfile("huge"); err == nil || !strings.Contains(err.Error(), "ha, minimal, standard") {
+		t.Errorf("Expected an unknown profile to list the profiles, got %v", err)
+	}
+}
+
+func TestResourcePatch(t *testing.T) {
+	patch, err := Profiles["ha"].ResourcePatch()
+	if err != nil {
+		t.Fatalf("ResourcePatch failed %v", err)
+	}
+	for _, want := range []string{
+		"op: add",
+		"path: /spec/template/spec/containers/0/resources",
+		"cpu: 100m",
+		"memory: 128Mi",
+	} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("Expected %q in the patch, got\n%s", want, patch)
+		}
+	}
+	if strings.Contains(patch, "limits") {
+		t.Errorf("Expected no limits in the patch, got\n%s", patch)
+	}
+	if patch, err := (Profile{}).ResourcePatch(); err != nil || patch != "" {
+		t.Errorf("Expected no patch without resources, got %q %v", patch, err)
+	}
+}
+
+func TestCreateGiteaReplicas(t *testing.T) {
+	k, client := newFakeK8s(t)
+	ha := Profiles["ha"]
+	if err := k.CreateGitea("", GiteaOptions{
+		User:             "alice",
+		Password:         "secret",
+		Domain:           "git.example.com",
+		Replicas:         ha.GiteaReplicas,
+		PostgresReplicas: ha.PostgresReplicas,
+		Requests:         ha.Requests,
+	}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+	gitea, err := client.Resource(giteaGVR).Namespace(DEFAULT).Get(context.TODO(), GITEA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected gitea to be created %v", err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(gitea.Object, SPEC, "replicas"); replicas != 2 {
+		t.Errorf("Expected 2 gitea replicas, got %d", replicas)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(gitea.Object, SPEC, "postgres", "replicas"); replicas != 3 {
+		t.Errorf("Expected 3 postgres replicas, got %d", replicas)
+	}
+	lr, err := client.Resource(limitRangeGVR).Namespace(DEFAULT).Get(context.TODO(), "pivot-default-requests", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the default requests of the gitea namespace %v", err)
+	}
+	limits, _, _ := unstructured.NestedSlice(lr.Object, SPEC, "limits")
+	if len(limits) != 1 {
+		t.Fatalf("Expected one container default, got %v", limits)
+	}
+	if memory, _, _ := unstructured.NestedString(limits[0].(map[string]interface{}), "defaultRequest", "memory"); memory != "128Mi" {
+		t.Errorf("Expected the profile's memory request, got %q", memory)
+	}
+}
+
+func TestCreateGiteaWithoutRequests(t *testing.T) {
+	k, client := newFakeK8s(t)
+	if err := k.CreateGitea("", GiteaOptions{User: "alice", Password: "secret", Domain: "git.example.com"}); err != nil {
+		t.Fatalf("CreateGitea failed %v", err)
+	}
+	if _, err := client.Resource(limitRangeGVR).Namespace(DEFAULT).Get(context.TODO(), "pivot-default-requests", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected no LimitRange in the shared namespace unless requested")
+	}
+}
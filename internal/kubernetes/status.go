@@ -0,0 +1,206 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	applicationGVR = schema.GroupVersionResource{
+		Group:    "argoproj.io",
+		Version:  "v1alpha1",
+		Resource: "applications",
+	}
+	giteaGVR = schema.GroupVersionResource{
+		Group:    "hyperspike.io",
+		Version:  "v1",
+		Resource: GITEA,
+	}
+	postgresqlGVR = schema.GroupVersionResource{
+		Group:    "acid.zalan.do",
+		Version:  "v1",
+		Resource: "postgresqls",
+	}
+)
+
+// WorkloadStatus is the readiness of a Deployment, StatefulSet or DaemonSet.
+type WorkloadStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Ready     int64  `json:"ready"`
+	Desired   int64  `json:"desired"`
+}
+
+// ResourceStatus is the summarized status of a custom resource.
+type ResourceStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ComponentStatus describes a single platform component, combining its
+// inventory, workloads and the Argo CD Application deploying it.
+type ComponentStatus struct {
+	Component      string           `json:"component"`
+	Version        string           `json:"version,omitempty"`
+	Revision       string           `json:"revision,omitempty"`
+	RunID          string           `json:"runId,omitempty"`
+	Ready          bool             `json:"ready"`
+	Workloads      []WorkloadStatus `json:"workloads,omitempty"`
+	Sync           string           `json:"sync,omitempty"`
+	Health         string           `json:"health,omitempty"`
+	SyncedRevision string           `json:"syncedRevision,omitempty"`
+}
+
+// PlatformStatus is the overall state of a bootstrapped cluster.
+type PlatformStatus struct {
+	Components []ComponentStatus `json:"components"`
+	Gitea      *ResourceStatus   `json:"gitea,omitempty"`
+	Postgres   []ResourceStatus  `json:"postgres,omitempty"`
+}
+
+// Status collects the state of every component pivot has applied or Argo CD
+// is deploying.
+func (k *K8s) Status() (*PlatformStatus, error) {
+	components := map[string]*ComponentStatus{}
+	inventories, err := k.ListInventories()
+	if err != nil {
+		return nil, err
+	}
+	for _, inv := range inventories {
+		c := &ComponentStatus{
+			Component: inv.Component,
+			Version:   inv.Version,
+			Revision:  inv.Revision,
+			RunID:     inv.RunID,
+			Ready:     true,
+		}
+		for _, e := range inv.Entries {
+			if e.Kind != "Deployment" && e.Kind != "StatefulSet" && e.Kind != "DaemonSet" {
+				continue
+			}
+			w, err := k.workloadStatus(e)
+			if err != nil {
+				return nil, err
+			}
+			if w.Ready < w.Desired {
+				c.Ready = false
+			}
+			c.Workloads = append(c.Workloads, *w)
+		}
+		components[inv.Component] = c
+	}
+
+	apps, err := k.client.Resource(applicationGVR).Namespace(ARGOCD).List(k.ctx, metav1.ListOptions{})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		k.log.Errorw("failed to list applications", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
+	if apps != nil {
+		for _, app := range apps.Items {
+			c, ok := components[app.GetName()]
+			if !ok {
+				c = &ComponentStatus{Component: app.GetName(), Ready: true}
+				components[app.GetName()] = c
+			}
+			c.Sync, _, _ = unstructured.NestedString(app.Object, "status", "sync", "status")
+			c.Health, _, _ = unstructured.NestedString(app.Object, "status", "health", "status")
+			c.SyncedRevision, _, _ = unstructured.NestedString(app.Object, "status", "sync", "revision")
+		}
+	}
+
+	status := &PlatformStatus{}
+	for _, c := range components {
+		status.Components = append(status.Components, *c)
+	}
+	sort.Slice(status.Components, func(i, j int) bool {
+		return status.Components[i].Component < status.Components[j].Component
+	})
+
+	gitea, err := k.client.Resource(giteaGVR).Namespace(DEFAULT).Get(k.ctx, GITEA, metav1.GetOptions{})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		k.log.Errorw("failed to get gitea", "error", err)
+		return nil, errors.Wrap(err, "")
+	} else if err == nil {
+		s := customResourceStatus(gitea)
+		status.Gitea = &s
+	}
+
+	pgs, err := k.client.Resource(postgresqlGVR).Namespace(DEFAULT).List(k.ctx, metav1.ListOptions{})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		k.log.Errorw("failed to list postgres clusters", "error", err)
+		return nil, errors.Wrap(err, "")
+	} else if err == nil {
+		for i := range pgs.Items {
+			status.Postgres = append(status.Postgres, customResourceStatus(&pgs.Items[i]))
+		}
+	}
+	return status, nil
+}
+
+// Behind reports whether Argo CD last synced c from a revision other than
+// head, false when either is unknown.
+func (c ComponentStatus) Behind(head string) bool {
+	return c.SyncedRevision != "" && head != "" && c.SyncedRevision != head
+}
+
+func (k *K8s) workloadStatus(e InventoryEntry) (*WorkloadStatus, error) {
+	w := &WorkloadStatus{Kind: e.Kind, Namespace: e.Namespace, Name: e.Name}
+	obj, err := k.client.Resource(e.gvr()).Namespace(e.Namespace).Get(k.ctx, e.Name, metav1.GetOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return w, nil
+	} else if err != nil {
+		k.log.Errorw("failed to get workload", "error", err, KIND, e.Kind, NAME, e.Name)
+		return nil, errors.Wrap(err, "")
+	}
+	if e.Kind == "DaemonSet" {
+		w.Desired, _, _ = unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+		w.Ready, _, _ = unstructured.NestedInt64(obj.Object, "status", "numberReady")
+		return w, nil
+	}
+	w.Desired = 1
+	if replicas, found, _ := unstructured.NestedInt64(obj.Object, SPEC, "replicas"); found {
+		w.Desired = replicas
+	}
+	w.Ready, _, _ = unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	return w, nil
+}
+
+// customResourceStatus summarizes obj from a Ready condition, falling back to
+// the status fields used by the gitea and postgres operators.
+func customResourceStatus(obj *unstructured.Unstructured) ResourceStatus {
+	s := ResourceStatus{Namespace: obj.GetNamespace(), Name: obj.GetName(), Status: "Unknown"}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		if cond["status"] == "True" {
+			s.Status = "Ready"
+		} else {
+			s.Status = "NotReady"
+		}
+		s.Message, _ = cond["message"].(string)
+		return s
+	}
+	if ready, found, _ := unstructured.NestedBool(obj.Object, "status", "ready"); found {
+		s.Status = "NotReady"
+		if ready {
+			s.Status = "Ready"
+		}
+		return s
+	}
+	if phase, found, _ := unstructured.NestedString(obj.Object, "status", "PostgresClusterStatus"); found {
+		s.Status = phase
+	}
+	return s
+}
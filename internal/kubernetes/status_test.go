@@ -0,0 +1,118 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// workload returns a Deployment or StatefulSet of default with ready of
+// replicas ready.
+func workload(kind, name string, replicas, ready int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "apps/v1",
+		KIND:       kind,
+		METADATA:   map[string]interface{}{NAME: name, NAMESPACE: DEFAULT},
+		SPEC:       map[string]interface{}{"replicas": replicas},
+		"status":   map[string]interface{}{"readyReplicas": ready},
+	}}
+}
+
+func application(name, sync, health, revision string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: "argoproj.io/v1alpha1",
+		KIND:       "Application",
+		METADATA:   map[string]interface{}{NAME: name, NAMESPACE: ARGOCD},
+		"status": map[string]interface{}{
+			"sync":   map[string]interface{}{"status": sync, "revision": revision},
+			"health": map[string]interface{}{"status": health},
+		},
+	}}
+}
+
+func customResource(apiVersion, kind, name string, status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		APIVERSION: apiVersion,
+		KIND:       kind,
+		METADATA:   map[string]interface{}{NAME: name, NAMESPACE: DEFAULT},
+		"status":   status,
+	}}
+}
+
+func TestStatus(t *testing.T) {
+	valkey := workload("Deployment", "valkey", 1, 1)
+	postgres := workload("StatefulSet", "gitea-postgres", 2, 1)
+	gitea := customResource("hyperspike.io/v1", "Gitea", GITEA, map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "False", "message": "waiting for postgres"},
+		},
+	})
+	k, client := newFakeK8s(t,
+		valkey, postgres,
+		customResource("acid.zalan.do/v1", "postgresql", "gitea-postgres", map[string]interface{}{"PostgresClusterStatus": "Running"}),
+		application(GITEA, "Synced", "Progressing", "0123456789abcdef"),
+		application(ARGOCD, "Synced", "Healthy", "fedcba9876543210"),
+	)
+	// the resource of the Gitea CR is not its guessed plural
+	if _, err := client.Resource(giteaGVR).Namespace(DEFAULT).Create(context.TODO(), gitea, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	k.SetSource("0123456789abcdef", map[string]string{GITEA: "v1.0.0"})
+	for _, obj := range []*unstructured.Unstructured{valkey, postgres} {
+		gvr, _ := k.resourceFor(obj.GroupVersionKind())
+		k.track(GITEA, gvr, obj)
+	}
+	if err := k.SaveInventory(GITEA); err != nil {
+		t.Fatalf("SaveInventory failed %v", err)
+	}
+
+	status, err := k.Status()
+	if err != nil {
+		t.Fatalf("Status failed %v", err)
+	}
+	if len(status.Components) != 2 || status.Components[0].Component != ARGOCD || status.Components[1].Component != GITEA {
+		t.Fatalf("Expected the argocd and gitea components, got %+v", status.Components)
+	}
+	argo, g := status.Components[0], status.Components[1]
+	if !argo.Ready || argo.Health != "Healthy" || len(argo.Workloads) != 0 {
+		t.Errorf("Expected the argocd Application without inventory to be reported, got %+v", argo)
+	}
+	if g.Ready || g.Version != "v1.0.0" || g.Sync != "Synced" || g.Health != "Progressing" {
+		t.Errorf("Expected gitea to be synced but not ready, got %+v", g)
+	}
+	ready := map[string][2]int64{}
+	for _, w := range g.Workloads {
+		ready[w.Kind+"/"+w.Name] = [2]int64{w.Ready, w.Desired}
+	}
+	if ready["Deployment/valkey"] != [2]int64{1, 1} || ready["StatefulSet/gitea-postgres"] != [2]int64{1, 2} {
+		t.Errorf("Expected the readiness of the workloads, got %v", ready)
+	}
+	if g.Behind("0123456789abcdef") || !argo.Behind("0123456789abcdef") || argo.Behind("") {
+		t.Errorf("Expected only argocd to be behind HEAD")
+	}
+
+	if status.Gitea == nil || status.Gitea.Status != "NotReady" || status.Gitea.Message != "waiting for postgres" {
+		t.Errorf("Expected the Ready condition of the Gitea CR, got %+v", status.Gitea)
+	}
+	if len(status.Postgres) != 1 || status.Postgres[0].Status != "Running" {
+		t.Errorf("Expected the postgres cluster status, got %+v", status.Postgres)
+	}
+}
+
+func TestCustomResourceStatusFallback(t *testing.T) {
+	for _, tc := range []struct {
+		status map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"ready": true}, "Ready"},
+		{map[string]interface{}{"ready": false}, "NotReady"},
+		{map[string]interface{}{}, "Unknown"},
+	} {
+		got := customResourceStatus(customResource("hyperspike.io/v1", "Gitea", GITEA, tc.status))
+		if got.Status != tc.want {
+			t.Errorf("Expected %s for %v, got %+v", tc.want, tc.status, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package kubernetes
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Redacted replaces secret values in a support bundle.
+const Redacted = "REDACTED"
+
+// Redact blanks the values of a Secret, and the last applied configuration
+// kubectl keeps them in, leaving other objects untouched.
+func Redact(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj.GetKind() != "Secret" {
+		return obj
+	}
+	obj = obj.DeepCopy()
+	for _, field := range []string{"data", "stringData"} {
+		values, found, _ := unstructured.NestedMap(obj.Object, field)
+		if !found {
+			continue
+		}
+		for key := range values {
+			values[key] = Redacted
+		}
+		_ = unstructured.SetNestedMap(obj.Object, values, field)
+	}
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		annotations["kubectl.kubernetes.io/last-applied-configuration"] = Redacted
+		obj.SetAnnotations(annotations)
+	}
+	return obj
+}
+
+// ManagedObjects returns, by component, every object of the inventories
+// with its status, Secrets redacted. Objects deleted since they were
+// applied are left out.
+func (k *K8s) ManagedObjects() (map[string][]unstructured.Unstructured, error) {
+	inventories, err := k.ListInventories()
+	if err != nil {
+		return nil, err
+	}
+	objects := map[string][]unstructured.Unstructured{}
+	for _, inv := range inventories {
+		for _, e := range inv.Entries {
+			obj, err := k.client.Resource(e.gvr()).Namespace(e.Namespace).Get(k.ctx, e.Name, metav1.GetOptions{})
+			if err != nil && strings.Contains(err.Error(), "not found") {
+				continue
+			} else if err != nil {
+				k.log.Errorw("failed to get object", "error", err, KIND, e.Kind, NAMESPACE, e.Namespace, NAME, e.Name)
+				return nil, errors.Wrap(err, "")
+			}
+			objects[inv.Component] = append(objects[inv.Component], *Redact(obj))
+		}
+	}
+	return objects, nil
+}
+
+// Applications returns the Argo CD Applications, with their conditions and
+// last operation.
+func (k *K8s) Applications() ([]unstructured.Unstructured, error) {
+	list, err := k.client.Resource(applicationGVR).Namespace(ARGOCD).List(k.ctx, metav1.ListOptions{})
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return nil, nil
+	} else if err != nil {
+		k.log.Errorw("failed to list applications", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
+	return list.Items, nil
+}
+
+// Namespaces returns the namespaces of the components.
+func (k *K8s) Namespaces() []string {
+	namespaces := []string{}
+	for ns := range k.namespaceComponents() {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// RecentEvents returns the Events of namespace last seen after since,
+// oldest first.
+func (k *K8s) RecentEvents(namespace string, since time.Time) ([]unstructured.Unstructured, error) {
+	list, err := k.client.Resource(eventGVR).Namespace(namespace).List(k.ctx, metav1.ListOptions{})
+	if err != nil {
+		k.log.Errorw("failed to list events", "error", err, NAMESPACE, namespace)
+		return nil, errors.Wrap(err, "")
+	}
+	events := []unstructured.Unstructured{}
+	for _, e := range list.Items {
+		if !lastSeen(&e).Before(since) {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return lastSeen(&events[i]).Before(lastSeen(&events[j]))
+	})
+	return events, nil
+}
+
+// lastSeen is when event last occurred, its creation when not recorded.
+func lastSeen(event *unstructured.Unstructured) time.Time {
+	if ts, _, _ := unstructured.NestedString(event.Object, "lastTimestamp"); ts != "" {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return t
+		}
+	}
+	return event.GetCreationTimestamp().Time
+}
+
+// Pods returns the pods of namespace an object of the inventories controls,
+// directly or through the objects it creates, leaving out the pods pivot
+// did not deploy.
+func (k *K8s) Pods(namespace string) ([]unstructured.Unstructured, error) {
+	owned, err := k.inventoryObjects()
+	if err != nil {
+		return nil, err
+	}
+	list, err := k.client.Resource(podGVR).Namespace(namespace).List(k.ctx, metav1.ListOptions{})
+	if err != nil {
+		k.log.Errorw("failed to list pods", "error", err, NAMESPACE, namespace)
+		return nil, errors.Wrap(err, "")
+	}
+	pods := []unstructured.Unstructured{}
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if k.ownerComponent(owned, namespace, "v1", "Pod", pod.GetName(), pod) != "" {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, nil
+}
+
+// PodLogs writes the last tail lines of the log of a container of
+// namespace/pod to w.
+func (k *K8s) PodLogs(namespace, pod, container string, tail int64, w io.Writer) error {
+	_, client, err := k.coreClient("logs")
+	if err != nil {
+		return err
+	}
+	logs, err := client.Get().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("log").
+		VersionedParams(&corev1.PodLogOptions{
+			Container: container,
+			TailLines: &tail,
+		}, scheme.ParameterCodec).
+		Stream(k.ctx)
+	if err != nil {
+		k.log.Errorw("failed to get logs", "error", err, "pod", pod, "container", container)
+		return errors.Wrap(err, "")
+	}
+	defer func() {
+		if err := logs.Close(); err != nil {
+			k.log.Errorw("error closing logs", "error", err)
+		}
+	}()
+	if _, err := io.Copy(w, logs); err != nil {
+		return errors.Wrap(err, "")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestManagedObjectsRedactsSecrets(t *testing.T) {
+	k, _ := newFakeK8s(t)
+	if err := k.CreateBackupSchedule(BackupOptions{
+		Schedule:    "0 3 * * *",
+		To:          "s3://backups/pivot/",
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+		Image:       "ghcr.io/hyperspike/pivot:latest",
+	}); err != nil {
+		t.Fatalf("CreateBackupSchedule failed %v", err)
+	}
+	objects, err := k.ManagedObjects()
+	if err != nil {
+		t.Fatalf("ManagedObjects failed %v", err)
+	}
+	if len(objects[BACKUP]) != 5 {
+		t.Fatalf("Expected the 5 backup objects, got %d", len(objects[BACKUP]))
+	}
+	for _, obj := range objects[BACKUP] {
+		if obj.GetKind() != "Secret" {
+			continue
+		}
+		for _, field := range []string{"data", "stringData"} {
+			values, _, _ := unstructured.NestedStringMap(obj.Object, field)
+			for key, value := range values {
+				if value != Redacted {
+					t.Errorf("Expected %s of the secret to be redacted, got %q", key, value)
+				}
+			}
+		}
+	}
+	if key, err := k.GetSecretValue(DEFAULT, backupName+"-s3", "AWS_SECRET_ACCESS_KEY"); err != nil || key != "secret" {
+		t.Errorf("Expected the secret in the cluster to be untouched, got %v %v", key, err)
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	now := time.Now()
+	k, _ := newFakeK8s(t,
+		event(DEFAULT, "new", "FailedMount", "new", now),
+		event(DEFAULT, "old", "FailedMount", "old", now.Add(-2*time.Hour)),
+		event(DEFAULT, "older", "FailedMount", "older", now.Add(-30*time.Minute)),
+	)
+	events, err := k.RecentEvents(DEFAULT, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("RecentEvents failed %v", err)
+	}
+	if len(events) != 2 || events[0].GetName() != "older" || events[1].GetName() != "new" {
+		t.Errorf("Expected the events of the last hour oldest first, got %d", len(events))
+	}
+}
@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// waitInterval is how often WaitForApplications polls Argo CD.
+var waitInterval = 10 * time.Second
+
+// ApplicationStatus is the sync and health of an Argo CD Application along
+// with the messages explaining why it is not ready.
+type ApplicationStatus struct {
+	Name     string   `json:"name"`
+	Sync     string   `json:"sync,omitempty"`
+	Health   string   `json:"health,omitempty"`
+	Messages []string `json:"messages,omitempty"`
+}
+
+// Ready reports whether the Application is Synced and Healthy.
+func (a ApplicationStatus) Ready() bool {
+	return a.Sync == "Synced" && a.Health == "Healthy"
+}
+
+// WaitForApplications waits until the init Application and every Application
+// of the init ApplicationSet are Synced and Healthy. The last seen status of
+// each is returned, with an error if they did not all converge in time.
+// Failures to list the Applications are retried until the timeout.
+func (k *K8s) WaitForApplications(timeout time.Duration) ([]ApplicationStatus, error) {
+	if k.dryRun {
+		k.log.Info("Dry run: Not waiting for applications")
+		return nil, nil
+	}
+	deadline := time.Now().Add(timeout)
+	var statuses []ApplicationStatus
+	for {
+		// the API server may be briefly unavailable while Argo CD rolls out
+		current, err := k.applicationStatuses()
+		if err != nil {
+			if time.Now().After(deadline) {
+				return statuses, err
+			}
+			k.log.Warnw("Failed to list applications, retrying", "error", err)
+			select {
+			case <-k.ctx.Done():
+				return statuses, errors.Wrap(k.ctx.Err(), "")
+			case <-time.After(waitInterval):
+			}
+			continue
+		}
+		statuses = current
+		pending := []string{}
+		for _, s := range statuses {
+			if !s.Ready() {
+				pending = append(pending, s.Name)
+			}
+		}
+		if len(pending) == 0 {
+			k.log.Infow("All applications are synced and healthy", "applications", len(statuses))
+			return statuses, nil
+		}
+		if time.Now().After(deadline) {
+			return statuses, fmt.Errorf("timed out after %s waiting for %s", timeout, strings.Join(pending, ", "))
+		}
+		k.log.Infow("Waiting for applications", "pending", pending)
+		select {
+		case <-k.ctx.Done():
+			return statuses, errors.Wrap(k.ctx.Err(), "")
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+// applicationStatuses returns the status of the init Application, every
+// component and any other Application owned by the init ApplicationSet.
+// Components Argo CD has not generated yet are reported as Missing.
+func (k *K8s) applicationStatuses() ([]ApplicationStatus, error) {
+	list, err := k.client.Resource(applicationGVR).Namespace(ARGOCD).List(k.ctx, metav1.ListOptions{})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		k.log.Errorw("failed to list applications", "error", err)
+		return nil, errors.Wrap(err, "")
+	}
+	wanted := map[string]bool{INIT: true}
+	for _, c := range k.components {
+		wanted[c.Path] = true
+	}
+	statuses := map[string]ApplicationStatus{}
+	if list != nil {
+		for i := range list.Items {
+			app := &list.Items[i]
+			if !wanted[app.GetName()] && !ownedByInit(app) {
+				continue
+			}
+			statuses[app.GetName()] = applicationStatus(app)
+		}
+	}
+	for name := range wanted {
+		if _, ok := statuses[name]; !ok {
+			statuses[name] = ApplicationStatus{Name: name, Sync: "Missing", Health: "Missing"}
+		}
+	}
+	sorted := make([]ApplicationStatus, 0, len(statuses))
+	for _, s := range statuses {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted, nil
+}
+
+func ownedByInit(app *unstructured.Unstructured) bool {
+	for _, ref := range app.GetOwnerReferences() {
+		if ref.Kind == "ApplicationSet" && ref.Name == INIT {
+			return true
+		}
+	}
+	return false
+}
+
+// applicationStatus collects the Application's conditions, health message
+// and last operation message.
+func applicationStatus(app *unstructured.Unstructured) ApplicationStatus {
+	s := ApplicationStatus{Name: app.GetName()}
+	s.Sync, _, _ = unstructured.NestedString(app.Object, "status", "sync", "status")
+	s.Health, _, _ = unstructured.NestedString(app.Object, "status", "health", "status")
+	conditions, _, _ := unstructured.NestedSlice(app.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		s.Messages = append(s.Messages, fmt.Sprintf("%v: %v", cond["type"], cond["message"]))
+	}
+	if msg, _, _ := unstructured.NestedString(app.Object, "status", "health", "message"); msg != "" {
+		s.Messages = append(s.Messages, msg)
+	}
+	if phase, _, _ := unstructured.NestedString(app.Object, "status", "operationState", "phase"); phase == "Failed" || phase == "Error" {
+		msg, _, _ := unstructured.NestedString(app.Object, "status", "operationState", "message")
+		s.Messages = append(s.Messages, phase+": "+msg)
+	}
+	return s
+}
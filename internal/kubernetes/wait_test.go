@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func fakeApplication(name, sync, health string, conditions ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			APIVERSION: "argoproj.io/v1alpha1",
+			KIND:       "Application",
+			METADATA: map[string]interface{}{
+				NAME:      name,
+				NAMESPACE: ARGOCD,
+			},
+			"status": map[string]interface{}{
+				"sync":       map[string]interface{}{"status": sync},
+				"health":     map[string]interface{}{"status": health},
+				"conditions": conditions,
+			},
+		},
+	}
+}
+
+func TestWaitForApplications(t *testing.T) {
+	interval := waitInterval
+	t.Cleanup(func() { waitInterval = interval })
+	waitInterval = 0
+	objects := []runtime.Object{}
+	for _, c := range DefaultComponents() {
+		objects = append(objects, fakeApplication(c.Path, "Synced", "Healthy"))
+	}
+	k, _ := newFakeK8s(t, objects...)
+	statuses, err := k.WaitForApplications(0)
+	if err != nil {
+		t.Fatalf("Expected every application to be ready, got %v", err)
+	}
+	if len(statuses) != len(DefaultComponents()) {
+		t.Errorf("Expected a status per component, got %v", statuses)
+	}
+
+	objects = objects[:len(objects)-2]
+	objects = append(objects, fakeApplication(GITEA, "OutOfSync", "Degraded", map[string]interface{}{
+		"type":    "SyncError",
+		"message": "the server could not find the requested resource",
+	}))
+	k, _ = newFakeK8s(t, objects...)
+	statuses, err = k.WaitForApplications(0)
+	if err == nil {
+		t.Fatalf("Expected waiting to time out")
+	}
+	if !strings.Contains(err.Error(), GITEA) || !strings.Contains(err.Error(), INIT) {
+		t.Errorf("Expected the pending applications in the error, got %v", err)
+	}
+	for _, s := range statuses {
+		switch s.Name {
+		case GITEA:
+			if len(s.Messages) != 1 || !strings.HasPrefix(s.Messages[0], "SyncError") {
+				t.Errorf("Expected the sync error to be reported, got %v", s.Messages)
+			}
+		case INIT:
+			if s.Sync != "Missing" {
+				t.Errorf("Expected init to be reported missing, got %v", s.Sync)
+			}
+		}
+	}
+}
+
+func TestWaitForApplicationsRetriesListErrors(t *testing.T) {
+	interval := waitInterval
+	t.Cleanup(func() { waitInterval = interval })
+	waitInterval = 0
+	objects := []runtime.Object{}
+	for _, c := range DefaultComponents() {
+		objects = append(objects, fakeApplication(c.Path, "Synced", "Healthy"))
+	}
+	k, client := newFakeK8s(t, objects...)
+	failures := 2
+	client.PrependReactor("list", "applications", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	statuses, err := k.WaitForApplications(time.Minute)
+	if err != nil || failures != 0 {
+		t.Fatalf("Expected transient list errors to be retried, got %v", err)
+	}
+	if len(statuses) != len(DefaultComponents()) {
+		t.Errorf("Expected a status per component, got %v", statuses)
+	}
+
+	failures = -1
+	if _, err := k.WaitForApplications(0); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected the list error once the wait timed out, got %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
@@ -56,11 +57,11 @@ func (f *Forwarder) createDialer(url *url.URL) (httpstream.Dialer, error) {
 	return dialer, nil
 }
 
-func NewForwarder(ctx context.Context, log *zap.SugaredLogger, kubeContext string) (*Forwarder, error) {
+func NewForwarder(ctx context.Context, log *zap.SugaredLogger, getter genericclioptions.RESTClientGetter) (*Forwarder, error) {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
-	log = log.Named("proxy").With("context", kubeContext)
+	log = log.Named("proxy").With("context", kubernetes.CurrentContext(getter))
 	f := &Forwarder{
 		ctx:          ctx,
 		IOStreams:    &genericiooptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr},
@@ -68,8 +69,7 @@ func NewForwarder(ctx context.Context, log *zap.SugaredLogger, kubeContext strin
 		ReadyChannel: make(chan struct{}),
 		log:          log,
 	}
-	kubernetes.KubeContext = kubeContext
-	rest, err := kubernetes.GetKubeConfig()
+	rest, err := kubernetes.GetKubeConfig(getter)
 	if err != nil {
 		f.log.Errorw("Failed to get kubeconfig", "error", err)
 		return nil, err
@@ -0,0 +1,252 @@
+package support
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	goyaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"hyperspike.io/pivot/internal/git"
+	"hyperspike.io/pivot/internal/kubernetes"
+)
+
+const (
+	// InfoFile holds the pivot version and config
+	InfoFile = "pivot.json"
+	// ErrorsFile lists what could not be collected
+	ErrorsFile = "errors.txt"
+)
+
+// secretKey matches config keys whose values are redacted.
+var secretKey = regexp.MustCompile(`(?i)pass|secret|token|key`)
+
+// secretValues match what looks like a secret in a log line, the groups
+// around it are kept: values of password, secret and token keys, bearer tokens
+// and the password of URLs.
+var secretValues = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:pass(?:wd|word)|secret|token|api[_-]?key)[\w-]*["']?\s*[:=]\s*["']?)[^\s"'&,;]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`),
+	regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+(@)`),
+}
+
+// Options selects what is collected into a bundle.
+type Options struct {
+	Version string
+	Commit  string
+	// Config is pivot's config, values of secret keys are redacted
+	Config map[string]interface{}
+	// Repo is the local infra repository, its log is left out when nil
+	Repo *git.Spool
+	// Commits of the repository log
+	Commits int
+	// Since is how far back Events are collected
+	Since time.Duration
+	// Tail is the number of log lines of each container
+	Tail int64
+}
+
+// Info is the InfoFile of a bundle.
+type Info struct {
+	Version string                 `json:"version"`
+	Commit  string                 `json:"commit,omitempty"`
+	Created time.Time              `json:"created"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+// bundle writes files to a gzipped tar, recording what failed to collect
+// rather than giving up on the rest.
+type bundle struct {
+	tw      *tar.Writer
+	created time.Time
+	errors  []string
+	log     *zap.SugaredLogger
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: b.created}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *bundle) failed(what string, err error) {
+	b.log.Warnw("failed to collect", "what", what, "error", err)
+	b.errors = append(b.errors, what+": "+err.Error())
+}
+
+// Create collects the state of the platform for troubleshooting and writes
+// it to w as a gzipped tar: the pivot version and config, the log of the
+// infra repository, every object pivot manages with its status, the Argo CD
+// Applications, recent Events and the logs of the pods pivot deployed.
+// Secret values are redacted in objects and the config, values that look
+// like passwords and tokens are masked in the logs.
+func Create(ctx context.Context, log *zap.SugaredLogger, k *kubernetes.K8s, w io.Writer, opts Options) error {
+	log = log.Named("support")
+	gz := gzip.NewWriter(w)
+	b := &bundle{tw: tar.NewWriter(gz), created: time.Now().UTC(), log: log}
+
+	info, err := json.MarshalIndent(Info{
+		Version: opts.Version,
+		Commit:  opts.Commit,
+		Created: b.created,
+		Config:  redactConfig(opts.Config),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.add(InfoFile, info); err != nil {
+		return err
+	}
+	if opts.Repo != nil {
+		if lines, err := opts.Repo.Log(opts.Commits); err != nil {
+			b.failed("repository log", err)
+		} else if err := b.add("repo/git-log.txt", []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if status, err := k.Status(); err != nil {
+		b.failed("status", err)
+	} else if err := b.addJSON("status.json", status); err != nil {
+		return err
+	}
+	if err := b.addObjects(k); err != nil {
+		return err
+	}
+	if apps, err := k.Applications(); err != nil {
+		b.failed("applications", err)
+	} else if err := b.addYAML("argocd/applications.yaml", apps); err != nil {
+		return err
+	}
+	since := b.created.Add(-opts.Since)
+	for _, ns := range k.Namespaces() {
+		if events, err := k.RecentEvents(ns, since); err != nil {
+			b.failed("events in "+ns, err)
+		} else if err := b.addYAML("events/"+ns+".yaml", events); err != nil {
+			return err
+		}
+		if err := b.addLogs(k, ns, opts.Tail); err != nil {
+			return err
+		}
+	}
+	if len(b.errors) > 0 {
+		if err := b.add(ErrorsFile, []byte(strings.Join(b.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	log.Infow("Support bundle complete", "errors", len(b.errors))
+	return nil
+}
+
+// addObjects adds the objects of each component to objects/<component>.yaml.
+func (b *bundle) addObjects(k *kubernetes.K8s) error {
+	objects, err := k.ManagedObjects()
+	if err != nil {
+		b.failed("managed objects", err)
+		return nil
+	}
+	components := make([]string, 0, len(objects))
+	for c := range objects {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		if err := b.addYAML("objects/"+c+".yaml", objects[c]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addLogs adds the last tail lines of every container of the pods pivot
+// deployed to namespace to logs/<namespace>/<pod>/<container>.log, masking
+// secret looking values.
+func (b *bundle) addLogs(k *kubernetes.K8s, namespace string, tail int64) error {
+	pods, err := k.Pods(namespace)
+	if err != nil {
+		b.failed("pods in "+namespace, err)
+		return nil
+	}
+	for _, pod := range pods {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", field)
+			for _, c := range containers {
+				name, _, _ := unstructured.NestedString(c.(map[string]interface{}), "name")
+				var logs bytes.Buffer
+				if err := k.PodLogs(namespace, pod.GetName(), name, tail, &logs); err != nil {
+					b.failed(fmt.Sprintf("logs of %s/%s/%s", namespace, pod.GetName(), name), err)
+					continue
+				}
+				if err := b.add(path.Join("logs", namespace, pod.GetName(), name+".log"), redactLog(logs.Bytes())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (b *bundle) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, data)
+}
+
+// addYAML adds objects as a multi-document YAML file.
+func (b *bundle) addYAML(name string, objects []unstructured.Unstructured) error {
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		y, err := goyaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("---\n")
+		buf.Write(y)
+	}
+	return b.add(name, buf.Bytes())
+}
+
+// redactConfig copies config with the values of secret keys redacted.
+func redactConfig(config map[string]interface{}) map[string]interface{} {
+	redacted := map[string]interface{}{}
+	for key, value := range config {
+		switch {
+		case secretKey.MatchString(key) && value != "" && value != nil:
+			redacted[key] = kubernetes.Redacted
+		default:
+			if nested, ok := value.(map[string]interface{}); ok {
+				value = redactConfig(nested)
+			}
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// redactLog masks the values of logs that look like secrets.
+func redactLog(logs []byte) []byte {
+	for _, re := range secretValues {
+		logs = re.ReplaceAll(logs, []byte("${1}"+kubernetes.Redacted+"${2}"))
+	}
+	return logs
+}